@@ -0,0 +1,155 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/cabbie/updates"
+)
+
+// plannedUpdate is the reviewable description of an update selected for install.
+type plannedUpdate struct {
+	UpdateID        string   `json:"update_id"`
+	RevisionNumber  int      `json:"revision_number"`
+	Title           string   `json:"title"`
+	KBArticleIDs    []string `json:"kb_article_ids"`
+	Severity        string   `json:"severity"`
+	RequiresReboot  bool     `json:"requires_reboot"`
+	MaxDownloadSize int      `json:"max_download_size"`
+	EulaAccepted    bool     `json:"eula_accepted"`
+	Categories      []string `json:"categories"`
+}
+
+// installPlan is the set of updates an install would apply, written for an external approver.
+type installPlan struct {
+	Generated time.Time       `json:"generated"`
+	Updates   []plannedUpdate `json:"updates"`
+	Meta      runMeta         `json:"meta,omitempty"`
+}
+
+// approvalDecision lists the updates an approver allowed to be installed. Approved updates use the
+// schema of the plan, so entries can be copied from it; only their update_id and revision_number are
+// read.
+type approvalDecision struct {
+	Approved []plannedUpdate `json:"approved"`
+}
+
+// newInstallPlan describes ups, ordered by UpdateID and RevisionNumber so plans of the same updates
+// are identical apart from the time they were generated.
+func newInstallPlan(ups []*updates.Update, now time.Time) installPlan {
+	p := installPlan{Generated: now, Updates: []plannedUpdate{}}
+	for _, u := range ups {
+		cats := make([]string, len(u.Categories))
+		for i, c := range u.Categories {
+			cats[i] = c.Name
+		}
+		p.Updates = append(p.Updates, plannedUpdate{
+			UpdateID:        u.Identity.UpdateID,
+			RevisionNumber:  u.Identity.RevisionNumber,
+			Title:           u.Title,
+			KBArticleIDs:    u.KBArticleIDs,
+			Severity:        u.MsrcSeverity,
+			RequiresReboot:  u.RequiresReboot(),
+			MaxDownloadSize: u.MaxDownloadSize,
+			EulaAccepted:    u.EulaAccepted,
+			Categories:      cats,
+		})
+	}
+	sort.Slice(p.Updates, func(i, j int) bool {
+		if p.Updates[i].UpdateID != p.Updates[j].UpdateID {
+			return p.Updates[i].UpdateID < p.Updates[j].UpdateID
+		}
+		return p.Updates[i].RevisionNumber < p.Updates[j].RevisionNumber
+	})
+	return p
+}
+
+func (p installPlan) write(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	if err := e.Encode(p); err != nil {
+		return fmt.Errorf("error encoding install plan: %v", err)
+	}
+	return nil
+}
+
+// writeInstallPlan saves the plan for ups to path.
+func writeInstallPlan(path string, ups []*updates.Update) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create install plan: %v", err)
+	}
+	p := newInstallPlan(ups, time.Now())
+	p.Meta = meta.clone()
+	if err := p.write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readApproval(r io.Reader) (approvalDecision, error) {
+	var d approvalDecision
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return d, fmt.Errorf("error decoding approval decision: %v", err)
+	}
+	for i, u := range d.Approved {
+		if strings.TrimSpace(u.UpdateID) == "" {
+			return approvalDecision{}, fmt.Errorf("approved update %d has no update_id", i+1)
+		}
+	}
+	return d, nil
+}
+
+// loadApproval reads the approval decision at path.
+func loadApproval(path string) (approvalDecision, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return approvalDecision{}, fmt.Errorf("failed to open approval decision: %v", err)
+	}
+	defer f.Close()
+	return readApproval(f)
+}
+
+// filter returns the updates whose UpdateID and RevisionNumber were approved, along with the titles
+// of those that were not. UpdateIDs are GUIDs and match regardless of case. A new revision of an
+// approved update must be approved again.
+func (d approvalDecision) filter(ups []*updates.Update) ([]*updates.Update, []string) {
+	approved := make(map[updates.Identity]bool)
+	for _, a := range d.Approved {
+		approved[approvalKey(a.UpdateID, a.RevisionNumber)] = true
+	}
+	var kept []*updates.Update
+	var rejected []string
+	for _, u := range ups {
+		if approved[approvalKey(u.Identity.UpdateID, u.Identity.RevisionNumber)] {
+			kept = append(kept, u)
+			continue
+		}
+		rejected = append(rejected, u.Title)
+	}
+	return kept, rejected
+}
+
+// approvalKey is the identity an approval of revision rev of the update id matches.
+func approvalKey(id string, rev int) updates.Identity {
+	return updates.Identity{UpdateID: strings.ToLower(strings.TrimSpace(id)), RevisionNumber: rev}
+}
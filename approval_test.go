@@ -0,0 +1,105 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/cabbie/updates"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInstallPlan(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	ups := []*updates.Update{
+		{Title: "B", Identity: updates.Identity{UpdateID: "b", RevisionNumber: 1}, KBArticleIDs: []string{"2"}},
+		{Title: "A2", Identity: updates.Identity{UpdateID: "a", RevisionNumber: 2}, Categories: []updates.Category{{Name: "Security Updates"}}},
+		{Title: "A1", Identity: updates.Identity{UpdateID: "a", RevisionNumber: 1}, RebootRequired: true},
+	}
+	p := newInstallPlan(ups, now)
+	var got []string
+	for _, u := range p.Updates {
+		got = append(got, u.Title)
+	}
+	if diff := cmp.Diff([]string{"A1", "A2", "B"}, got); diff != "" {
+		t.Errorf("newInstallPlan() order returned diff (-want +got):\n%s", diff)
+	}
+	if !p.Updates[0].RequiresReboot || p.Updates[1].Categories[0] != "Security Updates" {
+		t.Errorf("newInstallPlan() = %+v, missing reboot or category details", p.Updates)
+	}
+
+	var a, b bytes.Buffer
+	if err := p.write(&a); err != nil {
+		t.Fatalf("write() returned unexpected error: %v", err)
+	}
+	if err := newInstallPlan([]*updates.Update{ups[2], ups[0], ups[1]}, now).write(&b); err != nil {
+		t.Fatalf("write() returned unexpected error: %v", err)
+	}
+	if a.String() != b.String() {
+		t.Errorf("write() is not stable across input order:\n%s\n%s", a.String(), b.String())
+	}
+}
+
+func TestApprovalFilter(t *testing.T) {
+	d, err := readApproval(strings.NewReader(`{"approved": [{"update_id": "A", "revision_number": 2}, {"update_id": "c", "revision_number": 1}]}`))
+	if err != nil {
+		t.Fatalf("readApproval() returned unexpected error: %v", err)
+	}
+	ups := []*updates.Update{
+		{Title: "A1", Identity: updates.Identity{UpdateID: "a", RevisionNumber: 1}},
+		{Title: "A2", Identity: updates.Identity{UpdateID: "a", RevisionNumber: 2}},
+		{Title: "B", Identity: updates.Identity{UpdateID: "b", RevisionNumber: 1}},
+	}
+	kept, rejected := d.filter(ups)
+	var got []string
+	for _, u := range kept {
+		got = append(got, u.Title)
+	}
+	if diff := cmp.Diff([]string{"A2"}, got); diff != "" {
+		t.Errorf("filter() approved returned diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"A1", "B"}, rejected); diff != "" {
+		t.Errorf("filter() rejected returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestReadApproval(t *testing.T) {
+	// An approval copied from the plan is accepted as is.
+	var plan bytes.Buffer
+	ups := []*updates.Update{{Title: "A", Identity: updates.Identity{UpdateID: "a", RevisionNumber: 2}}}
+	if err := newInstallPlan(ups, time.Now()).write(&plan); err != nil {
+		t.Fatal(err)
+	}
+	approval := strings.Replace(plan.String(), `"updates"`, `"approved"`, 1)
+	d, err := readApproval(strings.NewReader(approval))
+	if err != nil {
+		t.Fatalf("readApproval(%s) returned unexpected error: %v", approval, err)
+	}
+	if kept, _ := d.filter(ups); len(kept) != 1 {
+		t.Errorf("filter() of an approval copied from the plan kept %d updates, want 1", len(kept))
+	}
+
+	for _, in := range []string{
+		`{"approved": [{"revision_number": 1}]}`,
+		`{"approved": [{"UpdateID": "a", "RevisionNumber": 1}]}`,
+		`{"approved": [{"update_id": " ", "revision_number": 1}]}`,
+	} {
+		if _, err := readApproval(strings.NewReader(in)); err == nil {
+			t.Errorf("readApproval(%s) returned nil error, want an error for the missing update_id", in)
+		}
+	}
+}
@@ -21,14 +21,20 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
 	"time"
 
 	"flag"
+	"github.com/google/cabbie/compliance"
+	"github.com/google/cabbie/logging"
 	"github.com/google/cabbie/metrics"
 	"github.com/google/cabbie/notification"
 	"github.com/google/cabbie/cablib"
+	"github.com/google/cabbie/search"
 	"github.com/google/cabbie/servicemgr"
+	"github.com/google/cabbie/session"
+	"github.com/google/cabbie/updatehistory"
 	"github.com/google/aukera/client"
 	"golang.org/x/sys/windows/registry"
 	"golang.org/x/sys/windows/svc/debug"
@@ -40,11 +46,23 @@ import (
 var (
 	elog             debug.Log
 	runInDebug       = flag.Bool("debug", false, "Run in debug mode")
+	logLevel         = flag.String("log_level", "", "Comma separated log levels, optionally per module, e.g. \"warning,updatehistory=debug\"")
+	readOnly         = flag.Bool("read_only", false, "Run in read-only audit mode; any operation that would modify the device fails")
+	meta             = runMeta{}
 	config           = new(Settings)
 	categoryDefaults = []string{"Critical Updates", "Definition Updates", "Security Updates"}
 	rebootEvent      = make(chan bool, 1)
 	rebootActive     = false
 
+	// Module loggers in the main package, see logging.SetLevels.
+	searchLog   = logging.For("search")
+	installLog  = logging.For("install")
+	downloadLog = logging.For("download")
+	historyLog  = logging.For("updatehistory")
+
+	// shutdownGracePeriod bounds how long the service waits for operations to stop after a stop request.
+	shutdownGracePeriod = 30 * time.Second
+
 	// Metrics
 	virusUpdateSuccess         = new(metrics.Bool)
 	listUpdateSuccess          = new(metrics.Bool)
@@ -54,8 +72,12 @@ var (
 	requiredUpdateCount        = new(metrics.Int)
 	enforcedUpdateCount        = new(metrics.Int)
 	enforcementWatcherFailures = new(metrics.Int)
+	heartbeatCount             = new(metrics.Int)
+	searchBackoffCount         = new(metrics.Int)
 	installHResult             = new(metrics.String)
 	searchHResult              = new(metrics.String)
+	compliancePercentage       = new(metrics.Float)
+	oldestPendingAge           = new(metrics.Int)
 )
 
 // Settings contains configurable options.
@@ -67,18 +89,86 @@ type Settings struct {
 	AukeraEnabled uint64
 	AukeraPort    uint64
 	AukeraName    string
+
+	// Operation timeouts in seconds. 0 disables the timeout.
+	SearchTimeout, DownloadTimeout, InstallTimeout uint64
+
+	// Interval in seconds between progress heartbeats. 0 disables the heartbeat.
+	HeartbeatInterval uint64
+
+	// ReadOnly prevents Cabbie from modifying the device.
+	ReadOnly uint64
+
+	// Active hours during which downloads and installs are deferred, as hours of the day (0-23).
+	// Equal values disable active hours.
+	ActiveHoursStart, ActiveHoursEnd uint64
+
+	// Maximum megabytes downloaded per run, or per calendar month when MonthlyBudget is
+	// enabled. 0 disables the budget.
+	DownloadBudget, MonthlyBudget uint64
+
+	// InstallBeta allows beta updates to be installed.
+	InstallBeta uint64
+
+	// AutoAcceptEula accepts the EULA of updates during install.
+	AutoAcceptEula uint64
+
+	// Number of times, and delay in seconds between, retrying installs that fail with one of the
+	// InstallRetryCodes.
+	InstallRetries, InstallRetryDelay uint64
+	InstallRetryCodes                 []string
+
+	// Interval in minutes between virus definition installs. Definition updates are left out of the
+	// main run while UpdateVirusDef is enabled.
+	VirusDefInterval uint64
+
+	// DeferToAntivirus skips definition updates when a third-party antivirus product is registered
+	// with Security Center, as it manages its own definitions.
+	DeferToAntivirus uint64
+
+	// HistoryWorkers is the number of history entries expanded in parallel. 0 uses the number of CPUs. The
+	// CABBIE_HISTORY_WORKERS environment variable takes precedence.
+	HistoryWorkers uint64
+
+	// PropertyRetries is the number of times a transient failure reading a COM property is retried.
+	PropertyRetries uint64
+
+	// KBCachePath is the file caching the KB article IDs of UpdateIDs between runs.
+	KBCachePath string
+
+	// LogLevel sets log verbosity, optionally per module. See logging.SetLevels.
+	LogLevel string
+}
+
+// historyWorkersEnv overrides HistoryWorkers, letting CI pin concurrency without changing the registry.
+const historyWorkersEnv = "CABBIE_HISTORY_WORKERS"
+
+// checkOSBuild warns when running on an OS build outside the tested range. Cabbie still runs, but
+// some features may be degraded.
+func checkOSBuild() {
+	build, err := cablib.OSBuild()
+	if err != nil {
+		elog.Warning(7, fmt.Sprintf("Unable to determine the OS build: %v", err))
+		return
+	}
+	degraded := cablib.DegradedFeatures(build)
+	if len(degraded) == 0 {
+		return
+	}
+	elog.Warning(7, fmt.Sprintf("Untested OS build: build=%d tested_range=%d-%d\nFeatures that may be degraded:\n%s",
+		build, cablib.MinTestedBuild, cablib.MaxTestedBuild, strings.Join(degraded, "\n")))
 }
 
 type tickers struct {
 	Default, Aukera, List, Virus, Driver, Enforcement *time.Ticker
 }
 
-func initTickers() tickers {
+func initTickers(virusInterval time.Duration) tickers {
 	return tickers{
 		Default:     time.NewTicker(24 * time.Hour),
 		Aukera:      time.NewTicker(5 * time.Minute),
 		List:        time.NewTicker(2 * time.Hour),
-		Virus:       time.NewTicker(30 * time.Minute),
+		Virus:       time.NewTicker(virusInterval),
 		Driver:      time.NewTicker(72 * time.Hour),
 		Enforcement: time.NewTicker(6 * time.Hour),
 	}
@@ -103,6 +193,18 @@ func newSettings() *Settings {
 		Deadline:           14,
 		NotifyAvailable:    1,
 		AukeraPort:         9119,
+		SearchTimeout:      1800,
+		DownloadTimeout:    7200,
+		InstallTimeout:     14400,
+		AutoAcceptEula:     1,
+		InstallRetries:     2,
+		InstallRetryDelay:  60,
+		VirusDefInterval:   30,
+		KBCachePath:        defaultKBCache,
+		DeferToAntivirus:   1,
+		PropertyRetries:    3,
+		// ERROR_SHARING_VIOLATION and WU_E_INSTALL_NOT_ALLOWED usually clear up on their own.
+		InstallRetryCodes: []string{"0x80070020", "0x80240016"},
 	}
 }
 
@@ -123,6 +225,13 @@ func (s *Settings) regLoad(path string) error {
 		elog.Info(1, fmt.Sprintf("AukeraName not found in registry, using default Name:\n%v", s.AukeraName))
 	}
 
+	if l, _, err := k.GetStringValue("LogLevel"); err == nil {
+		s.LogLevel = l
+	}
+	if p, _, err := k.GetStringValue("KBCachePath"); err == nil && p != "" {
+		s.KBCachePath = p
+	}
+
 	if m, _, err := k.GetStringsValue("RequiredCategories"); err == nil {
 		s.RequiredCategories = m
 	} else {
@@ -153,6 +262,60 @@ func (s *Settings) regLoad(path string) error {
 	if i, _, err := k.GetIntegerValue("AukeraPort"); err == nil {
 		s.AukeraPort = i
 	}
+	if i, _, err := k.GetIntegerValue("SearchTimeout"); err == nil {
+		s.SearchTimeout = i
+	}
+	if i, _, err := k.GetIntegerValue("DownloadTimeout"); err == nil {
+		s.DownloadTimeout = i
+	}
+	if i, _, err := k.GetIntegerValue("InstallTimeout"); err == nil {
+		s.InstallTimeout = i
+	}
+	if i, _, err := k.GetIntegerValue("HeartbeatInterval"); err == nil {
+		s.HeartbeatInterval = i
+	}
+	if i, _, err := k.GetIntegerValue("ReadOnly"); err == nil {
+		s.ReadOnly = i
+	}
+	if i, _, err := k.GetIntegerValue("ActiveHoursStart"); err == nil {
+		s.ActiveHoursStart = i
+	}
+	if i, _, err := k.GetIntegerValue("ActiveHoursEnd"); err == nil {
+		s.ActiveHoursEnd = i
+	}
+	if i, _, err := k.GetIntegerValue("DownloadBudget"); err == nil {
+		s.DownloadBudget = i
+	}
+	if i, _, err := k.GetIntegerValue("MonthlyBudget"); err == nil {
+		s.MonthlyBudget = i
+	}
+	if i, _, err := k.GetIntegerValue("InstallBeta"); err == nil {
+		s.InstallBeta = i
+	}
+	if i, _, err := k.GetIntegerValue("AutoAcceptEula"); err == nil {
+		s.AutoAcceptEula = i
+	}
+	if i, _, err := k.GetIntegerValue("InstallRetries"); err == nil {
+		s.InstallRetries = i
+	}
+	if i, _, err := k.GetIntegerValue("InstallRetryDelay"); err == nil {
+		s.InstallRetryDelay = i
+	}
+	if m, _, err := k.GetStringsValue("InstallRetryCodes"); err == nil {
+		s.InstallRetryCodes = m
+	}
+	if i, _, err := k.GetIntegerValue("VirusDefInterval"); err == nil && i > 0 {
+		s.VirusDefInterval = i
+	}
+	if i, _, err := k.GetIntegerValue("DeferToAntivirus"); err == nil {
+		s.DeferToAntivirus = i
+	}
+	if i, _, err := k.GetIntegerValue("HistoryWorkers"); err == nil {
+		s.HistoryWorkers = i
+	}
+	if i, _, err := k.GetIntegerValue("PropertyRetries"); err == nil {
+		s.PropertyRetries = i
+	}
 
 	return nil
 }
@@ -173,6 +336,15 @@ func startService(isDebug bool) error {
 	return nil
 }
 
+// operationContext returns a child of ctx bounded by a timeout in seconds. A timeout of 0 never
+// expires, although the context is still canceled along with ctx.
+func operationContext(ctx context.Context, timeout uint64) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+}
+
 func initMetrics() error {
 	var err error
 
@@ -211,6 +383,19 @@ func initMetrics() error {
 	if err != nil {
 		elog.Error(6, fmt.Sprintf("unable to create enforcementWatcherFailures metric: %v", err))
 	}
+	oldestPendingAge, err = metrics.NewInt(cablib.MetricRoot+"oldestPendingAge", cablib.MetricSvc)
+	if err != nil {
+		return fmt.Errorf("unable to initialize oldestPendingAge metric: %v", err)
+	}
+	heartbeatCount, err = metrics.NewCounter(cablib.MetricRoot+"heartbeatCount", cablib.MetricSvc)
+	if err != nil {
+		return fmt.Errorf("unable to initialize heartbeatCount metric: %v", err)
+	}
+
+	searchBackoffCount, err = metrics.NewCounter(cablib.MetricRoot+"searchBackoffCount", cablib.MetricSvc)
+	if err != nil {
+		return fmt.Errorf("unable to initialize searchBackoffCount metric: %v", err)
+	}
 
 	// string metrics
 	installHResult, err = metrics.NewString(cablib.MetricRoot+"installHResult", cablib.MetricSvc)
@@ -222,9 +407,27 @@ func initMetrics() error {
 		return fmt.Errorf("unable to initialize searchHResult metric: %v", err)
 	}
 
+	// float metrics
+	compliancePercentage, err = metrics.NewFloat(cablib.MetricRoot+"compliancePercentage", cablib.MetricSvc)
+	if err != nil {
+		return fmt.Errorf("unable to initialize compliancePercentage metric: %v", err)
+	}
+
 	return nil
 }
 
+func initHeartbeat(seconds uint64) {
+	if seconds == 0 {
+		return
+	}
+	cablib.SetHeartbeat(time.Duration(seconds)*time.Second, func(phase string, elapsed time.Duration) {
+		elog.Info(3, fmt.Sprintf("Still working, phase=%s, elapsed=%s", phase, elapsed.Round(time.Second)))
+		if err := heartbeatCount.Increment(); err != nil {
+			elog.Error(6, fmt.Sprintf("Error posting heartbeatCount metric:\n%v", err))
+		}
+	})
+}
+
 func setRebootMetric() {
 	rbr, err := cablib.RebootRequired()
 	if err != nil {
@@ -241,7 +444,47 @@ func setRebootMetric() {
 
 }
 
-func runMainLoop() error {
+func setComplianceMetric() {
+	s, err := session.New()
+	if err != nil {
+		elog.Error(6, fmt.Sprintf("Failed to create new Windows Update session: %v", err))
+		return
+	}
+	defer s.Close()
+
+	q, err := search.NewSearcher(s, "", config.WSUSServers, config.EnableThirdParty)
+	if err != nil {
+		elog.Error(6, fmt.Sprintf("Failed to create a new searcher object: %v", err))
+		return
+	}
+	defer q.Close()
+
+	uc, err := compliance.Applicable(q)
+	if err != nil {
+		elog.Error(6, fmt.Sprintf("Error calculating update compliance:\n%v", err))
+		return
+	}
+	defer uc.Close()
+
+	// Definition updates are released several times a day and would skew the result.
+	p, installed, total := compliance.Percentage(uc.Updates, true)
+	elog.Info(4, fmt.Sprintf("%d of %d applicable updates installed (%.1f%%).", installed, total, p))
+
+	if err := compliancePercentage.Set(p); err != nil {
+		elog.Error(6, fmt.Sprintf("Error posting compliancePercentage metric:\n%v", err))
+	}
+
+	age, id := compliance.OldestPending(uc.Updates, true, time.Now())
+	if age > 0 {
+		elog.Info(4, fmt.Sprintf("Oldest pending update %s has been available for %s.", id.UpdateID, age.Round(time.Hour)))
+	}
+	if err := oldestPendingAge.Set(int64(age / time.Second)); err != nil {
+		elog.Error(6, fmt.Sprintf("Error posting oldestPendingAge metric:\n%v", err))
+	}
+}
+
+// runMainLoop runs scheduled update operations until ctx is canceled.
+func runMainLoop(ctx context.Context) error {
 	if err := notification.CleanNotifications(cablib.SvcName); err != nil {
 		elog.Error(6, fmt.Sprintf("Error clearing old notifications:\n%v", err))
 	}
@@ -255,7 +498,7 @@ func runMainLoop() error {
 	setRebootMetric()
 
 	// Initialize service tickers.
-	t := initTickers()
+	t := initTickers(time.Duration(config.VirusDefInterval) * time.Minute)
 	defer t.stop()
 
 	// Run filesystem watcher for required updates configuration.
@@ -289,9 +532,12 @@ func runMainLoop() error {
 
 	for {
 		select {
+		case <-ctx.Done():
+			elog.Info(2, "Shutdown: main loop stopped.")
+			return nil
 		case <-t.Default.C:
 			i := installCmd{}
-			err := i.installUpdates()
+			err := i.installUpdates(ctx)
 			if e := updateInstallSuccess.Set(err == nil); e != nil {
 				elog.Error(6, fmt.Sprintf("Error posting metric:\n%v", e))
 			}
@@ -314,7 +560,7 @@ func runMainLoop() error {
 			}
 			if s[0].State == "open" {
 				i := installCmd{}
-				err := i.installUpdates()
+				err := i.installUpdates(ctx)
 				if e := updateInstallSuccess.Set(err == nil); e != nil {
 					elog.Error(6, fmt.Sprintf("Error posting updateInstallSuccess metric:\n%v", e))
 				}
@@ -325,7 +571,7 @@ func runMainLoop() error {
 			}
 		case <-t.List.C:
 			setRebootMetric()
-			requiredUpdates, optionalUpdates, err := listUpdates(true)
+			requiredUpdates, optionalUpdates, betaUpdates, err := listUpdates(ctx, true, nil)
 			if e := listUpdateSuccess.Set(err == nil); e != nil {
 				elog.Error(6, fmt.Sprintf("Error posting listUpdateSuccess metric:\n%v", e))
 			}
@@ -336,16 +582,18 @@ func runMainLoop() error {
 			if err := requiredUpdateCount.Set(int64(len(requiredUpdates))); err != nil {
 				elog.Error(6, fmt.Sprintf("Error posting requiredUpdateCount metric:\n%v", err))
 			}
+			setComplianceMetric()
 
 			if len(requiredUpdates) == 0 {
 				elog.Info(1, "No required updates needed to install.")
 				break
 			}
 
-			elog.Info(4, fmt.Sprintf("Found %d required updates.\nRequired updates:\n%s\nOptional updates:\n%s",
+			elog.Info(4, fmt.Sprintf("Found %d required updates.\nRequired updates:\n%s\nOptional updates:\n%s\nBeta updates:\n%s",
 				len(requiredUpdates),
 				strings.Join(requiredUpdates, "\n\n"),
-				strings.Join(optionalUpdates, "\n\n")),
+				strings.Join(optionalUpdates, "\n\n"),
+				strings.Join(betaUpdates, "\n\n")),
 			)
 
 			if config.NotifyAvailable == 1 {
@@ -356,13 +604,13 @@ func runMainLoop() error {
 
 			if config.Deadline != 0 {
 				i := installCmd{deadlineOnly: true}
-				if err := i.installUpdates(); err != nil {
+				if err := i.installUpdates(ctx); err != nil {
 					elog.Error(6, fmt.Sprintf("Error installing system updates:\n%v", err))
 				}
 			}
 		case <-t.Virus.C:
 			i := installCmd{virusDef: true}
-			err := i.installUpdates()
+			err := i.installUpdates(ctx)
 			if e := virusUpdateSuccess.Set(err == nil); e != nil {
 				elog.Error(6, fmt.Sprintf("Error posting virusUpdateSuccess metric:\n%v", err))
 			}
@@ -372,7 +620,7 @@ func runMainLoop() error {
 			}
 		case <-t.Driver.C:
 			i := installCmd{drivers: true}
-			err := i.installUpdates()
+			err := i.installUpdates(ctx)
 			if e := driverUpdateSuccess.Set(err == nil); e != nil {
 				elog.Error(6, fmt.Sprintf("Error posting driverUpdateSuccess metric:\n%v", e))
 			}
@@ -385,7 +633,7 @@ func runMainLoop() error {
 			if err != nil {
 				elog.Error(6, fmt.Sprintf("Error retrieving required updates from %q:\n%v", file, err))
 			}
-			if err := kbs.install(); err != nil {
+			if err := kbs.install(ctx); err != nil {
 				elog.Error(6, fmt.Sprintf("Error enforcing required updates:\n%v", err))
 			}
 		case <-t.Enforcement.C:
@@ -393,7 +641,7 @@ func runMainLoop() error {
 			if err != nil {
 				elog.Error(6, fmt.Sprintf("Error gathering required updates:\n%v", err))
 			}
-			if err := kbs.install(); err != nil {
+			if err := kbs.install(ctx); err != nil {
 				elog.Error(6, fmt.Sprintf("Error enforcing required updates:\n%v", err))
 			}
 		case <-rebootEvent:
@@ -423,11 +671,13 @@ func runMainLoop() error {
 func (m winSvc) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
 
 	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
-	errch := make(chan error)
+	errch := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	changes <- svc.Status{State: svc.StartPending}
 	go func() {
-		errch <- runMainLoop()
+		errch <- runMainLoop(ctx)
 	}()
 	elog.Info(2, "Service started.")
 	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
@@ -438,7 +688,8 @@ loop:
 		// Watch for the cabbie goroutine to fail for some reason.
 		case err := <-errch:
 			elog.Error(1, fmt.Sprintf("Cabbie goroutine has failed: %v", err))
-			break loop
+			changes <- svc.Status{State: svc.StopPending}
+			return ssec, errno
 		// Watch for service signals.
 		case c := <-r:
 			switch c.Cmd {
@@ -451,10 +702,27 @@ loop:
 			}
 		}
 	}
-	changes <- svc.Status{State: svc.StopPending}
+	changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(shutdownGracePeriod / time.Millisecond)}
+	shutdown(cancel, errch, shutdownGracePeriod)
 	return ssec, errno
 }
 
+// shutdown cancels the main loop, aborting any update job in progress, and waits up to grace for it
+// to persist state and release its COM objects.
+func shutdown(cancel context.CancelFunc, errch <-chan error, grace time.Duration) {
+	elog.Info(2, "Shutdown: stop requested, aborting operations in progress.")
+	cancel()
+	select {
+	case err := <-errch:
+		if err != nil {
+			elog.Error(1, fmt.Sprintf("Shutdown: main loop returned error: %v", err))
+		}
+		elog.Info(2, "Shutdown: complete.")
+	case <-time.After(grace):
+		elog.Error(1, fmt.Sprintf("Shutdown: operations did not stop within %s, exiting anyway.", grace))
+	}
+}
+
 func enableThirdPartyUpdates() error {
 	m, err := servicemgr.InitMgrService()
 	if err != nil {
@@ -475,6 +743,7 @@ func enableThirdPartyUpdates() error {
 }
 
 func main() {
+	flag.Var(meta, "meta", "Metadata in the form key=value recorded with the results of this run; may be repeated")
 	flag.Parse()
 	var err error
 
@@ -488,6 +757,7 @@ func main() {
 		}
 	}
 	defer elog.Close()
+	logging.SetSink(elog)
 
 	// Load Cabbie config settings.
 	config = newSettings()
@@ -501,10 +771,28 @@ func main() {
 		os.Exit(2)
 	}
 
+	// Command line log levels take precedence over the registry.
+	levels := config.LogLevel
+	if *logLevel != "" {
+		levels = *logLevel
+	}
+	if err := logging.SetLevels(levels); err != nil {
+		elog.Error(6, fmt.Sprintf("Invalid log level %q, using defaults: %v", levels, err))
+	}
+
 	// Initialize metrics.
 	if err := initMetrics(); err != nil {
 		elog.Error(6, err.Error())
 	}
+	initHeartbeat(config.HeartbeatInterval)
+	updatehistory.SetWorkers(cablib.Workers(historyWorkersEnv, int(config.HistoryWorkers)))
+	cablib.SetPropertyRetries(int(config.PropertyRetries))
+	checkOSBuild()
+
+	if *readOnly || config.ReadOnly == 1 {
+		elog.Info(0001, "Running in read-only mode, the device will not be modified.")
+		cablib.SetReadOnly(true)
+	}
 
 	// Running as Service.
 	// TODO: move service logic into its own subcommand.
@@ -517,16 +805,29 @@ func main() {
 	}
 
 	// Running Interactively.
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		<-c
+		elog.Info(0001, "Interrupt received, aborting operations in progress.")
+		cancel()
+	}()
 
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
 
+	subcommands.Register(&eulaCmd{}, "Update management")
 	subcommands.Register(&hideCmd{}, "Update management")
 	subcommands.Register(&historyCmd{}, "Update management")
 	subcommands.Register(&installCmd{}, "Update management")
 	subcommands.Register(&listCmd{}, "Update management")
+	subcommands.Register(&removableCmd{}, "Update management")
+	subcommands.Register(&retryCmd{}, "Update management")
+	subcommands.Register(&revisionsCmd{}, "Update management")
+	subcommands.Register(&sourcesCmd{}, "Update management")
+	subcommands.Register(&statusCmd{}, "Update management")
 	subcommands.Register(&serviceCmd{}, "Service registration management")
 
 	if *runInDebug {
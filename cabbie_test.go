@@ -15,7 +15,9 @@
 package main
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/sys/windows/registry"
@@ -113,3 +115,34 @@ func TestRegLoadRequiredCategories(t *testing.T) {
 		t.Errorf("testconfig.regLoad(%s) = %v, want %v", testPath, testconfig, expected)
 	}
 }
+
+func TestShutdown(t *testing.T) {
+	elog = new(testCabbieLog)
+	ctx, cancel := context.WithCancel(context.Background())
+	errch := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		errch <- nil
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(cancel, errch, time.Minute)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shutdown() did not return after the main loop stopped")
+	}
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	elog = new(testCabbieLog)
+	_, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	shutdown(cancel, make(chan error), 10*time.Millisecond)
+	if time.Since(start) > 10*time.Second {
+		t.Error("shutdown() did not return after the grace period")
+	}
+}
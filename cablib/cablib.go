@@ -16,10 +16,16 @@
 package cablib
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/cabbie/notification"
@@ -53,6 +59,17 @@ const (
 var (
 	now            = time.Now
 	rebootRequired = RebootRequired
+	// jobPollInterval is how often WaitForJob checks the state of an asynchronous job.
+	jobPollInterval = 5 * time.Second
+
+	readOnly int32
+
+	// ErrReadOnly is returned by operations that would modify the device while read-only mode is enabled.
+	ErrReadOnly = errors.New("operation not permitted in read-only mode")
+
+	heartbeatMu       sync.Mutex
+	heartbeatInterval time.Duration
+	heartbeatFunc     func(phase string, elapsed time.Duration)
 	// RegPath is the registry path to the cabbie settings.
 	RegPath = `SOFTWARE\Google\Cabbie\`
 )
@@ -93,8 +110,49 @@ func InitializeCOM() error {
 	return nil
 }
 
+// Workers resolves the size of a worker pool from the environment variable env, then configured,
+// then the number of CPUs. Values that are not positive are ignored so a pool always has a worker.
+func Workers(env string, configured int) int {
+	if n, err := strconv.Atoi(os.Getenv(env)); err == nil && n > 0 {
+		return n
+	}
+	if configured > 0 {
+		return configured
+	}
+	return runtime.NumCPU()
+}
+
+// SetReadOnly enables or disables read-only mode. While enabled, every operation that would modify
+// the device (hiding updates, accepting EULAs, downloading, installing, rebooting or changing update
+// services) fails with ErrReadOnly, letting embedding programs guarantee the device is left untouched.
+// Searches and history queries are unaffected.
+func SetReadOnly(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&readOnly, v)
+}
+
+// ReadOnly reports whether read-only mode is enabled.
+func ReadOnly() bool {
+	return atomic.LoadInt32(&readOnly) == 1
+}
+
+// CheckWritable is the single gate for operations that modify the device. It returns an error
+// wrapping ErrReadOnly, naming the operation, when read-only mode is enabled.
+func CheckWritable(op string) error {
+	if ReadOnly() {
+		return fmt.Errorf("%s: %w", op, ErrReadOnly)
+	}
+	return nil
+}
+
 // SetRebootTime creates the reboot time key.
 func SetRebootTime(seconds uint64) error {
+	if err := CheckWritable("schedule reboot"); err != nil {
+		return err
+	}
 	k, err := registry.OpenKey(registry.LOCAL_MACHINE, RegPath, registry.SET_VALUE)
 	if err != nil {
 		return err
@@ -144,6 +202,9 @@ func RebootTime() (time.Time, error) {
 
 // SystemReboot initates a restart when the set reboot time has passed. This should be called within a goroutine
 func SystemReboot(t time.Time) error {
+	if err := CheckWritable("reboot"); err != nil {
+		return err
+	}
 	time.Sleep(time.Until(t))
 
 	notification.RebootPopup(2)
@@ -162,6 +223,66 @@ func Count(id *ole.IDispatch) (int, error) {
 	return int(count.Val), nil
 }
 
+// SetHeartbeat configures WaitForJob to call fn every interval while it waits on a job, letting
+// callers signal that a long running operation is still making progress. An interval of 0 disables
+// the heartbeat.
+func SetHeartbeat(interval time.Duration, fn func(phase string, elapsed time.Duration)) {
+	heartbeatMu.Lock()
+	defer heartbeatMu.Unlock()
+
+	heartbeatInterval = interval
+	heartbeatFunc = fn
+}
+
+func heartbeat() (time.Duration, func(string, time.Duration)) {
+	heartbeatMu.Lock()
+	defer heartbeatMu.Unlock()
+
+	if heartbeatInterval <= 0 || heartbeatFunc == nil {
+		return 0, nil
+	}
+	return heartbeatInterval, heartbeatFunc
+}
+
+// WaitForJob polls an asynchronous Windows Update job (ISearchJob, IDownloadJob or IInstallationJob)
+// until it completes. If the context is cancelled or its deadline passes before then, an abort of the
+// job is requested and the context error is returned. Callers remain responsible for calling CleanUp on
+// the job. Phase names the operation being waited on for any configured heartbeat.
+func WaitForJob(ctx context.Context, job *ole.IDispatch, phase string) error {
+	t := time.NewTicker(jobPollInterval)
+	defer t.Stop()
+
+	interval, beat := heartbeat()
+	start := now()
+	lastBeat := start
+
+	for {
+		c, err := oleutil.GetProperty(job, "IsCompleted")
+		if err != nil {
+			return fmt.Errorf("error getting job IsCompleted property: %v", err)
+		}
+		done, _ := c.Value().(bool)
+		c.Clear()
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if _, err := oleutil.CallMethod(job, "RequestAbort"); err != nil {
+				return fmt.Errorf("%v; failed to abort job: %v", ctx.Err(), err)
+			}
+			return ctx.Err()
+		case <-t.C:
+		}
+
+		if beat != nil && now().Sub(lastBeat) >= interval {
+			lastBeat = now()
+			beat(phase, lastBeat.Sub(start))
+		}
+	}
+}
+
 // NewCOMObject creates a new COM object for the specifed ProgramID.
 func NewCOMObject(id string) (*ole.IDispatch, error) {
 	unknown, err := oleutil.CreateObject(id)
@@ -201,6 +322,61 @@ func RebootRequired() (bool, error) {
 	return r.Value().(bool), nil
 }
 
+// ThirdPartyAntivirus returns the display names of antivirus products registered with Security
+// Center other than Microsoft Defender. Such products typically manage their own definitions. Server
+// editions do not run Security Center and return an error.
+func ThirdPartyAntivirus() ([]string, error) {
+	if err := InitializeCOM(); err != nil {
+		return nil, err
+	}
+	defer ole.CoUninitialize()
+
+	locator, err := NewCOMObject("WbemScripting.SWbemLocator")
+	if err != nil {
+		return nil, err
+	}
+	defer locator.Release()
+
+	s, err := oleutil.CallMethod(locator, "ConnectServer", ".", `root\SecurityCenter2`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Security Center: %v", err)
+	}
+	svc := s.ToIDispatch()
+	defer svc.Release()
+
+	r, err := oleutil.CallMethod(svc, "ExecQuery", "SELECT displayName FROM AntiVirusProduct")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query antivirus products: %v", err)
+	}
+	products := r.ToIDispatch()
+	defer products.Release()
+
+	count, err := Count(products)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for i := 0; i < count; i++ {
+		item, err := oleutil.CallMethod(products, "ItemIndex", i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get antivirus product %d: %v", i, err)
+		}
+		itemd := item.ToIDispatch()
+		n, err := oleutil.GetProperty(itemd, "displayName")
+		itemd.Release()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get antivirus product name: %v", err)
+		}
+		name := n.ToString()
+		n.Clear()
+		if !strings.Contains(name, "Defender") {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
 // GetUpdateTitles loops through an update collection and returns a list of titles.
 func GetUpdateTitles(collection *ole.IDispatch, count int) ([]string, []error) {
 	var errors []error
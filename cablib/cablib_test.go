@@ -15,12 +15,16 @@ package cablib
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"os"
 	"reflect"
+	"runtime"
 	"testing"
 	"time"
 
 	"golang.org/x/sys/windows/registry"
+	"github.com/go-ole/go-ole"
 )
 
 const (
@@ -103,6 +107,23 @@ func TestSetRebootTime(t *testing.T) {
 	}
 }
 
+func TestReadOnly(t *testing.T) {
+	SetReadOnly(true)
+	defer SetReadOnly(false)
+
+	if err := CheckWritable("test"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("CheckWritable() = %v, want %v", err, ErrReadOnly)
+	}
+	if err := SetRebootTime(200); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("SetRebootTime() = %v, want %v", err, ErrReadOnly)
+	}
+
+	SetReadOnly(false)
+	if err := CheckWritable("test"); err != nil {
+		t.Errorf("CheckWritable() = %v, want nil", err)
+	}
+}
+
 func TestRebootTimeMissingValue(t *testing.T) {
 	// Setup
 	rebootRequired = testRebootFalse
@@ -244,3 +265,124 @@ func TestSliceContains(t *testing.T) {
 		}
 	}
 }
+
+func TestWorkers(t *testing.T) {
+	const env = "CABBIE_TEST_WORKERS"
+	defer os.Unsetenv(env)
+	for _, tt := range []struct {
+		env        string
+		configured int
+		want       int
+	}{
+		{"", 3, 3},
+		{"5", 3, 5},
+		{"0", 3, 3},
+		{"-2", 3, 3},
+		{"many", 3, 3},
+		{"", 0, runtime.NumCPU()},
+		{"-1", -1, runtime.NumCPU()},
+	} {
+		os.Setenv(env, tt.env)
+		if got := Workers(env, tt.configured); got != tt.want {
+			t.Errorf("Workers(%s=%q, %d) = %d, want %d", env, tt.env, tt.configured, got, tt.want)
+		}
+	}
+}
+
+func TestRebootState(t *testing.T) {
+	for _, tt := range []struct {
+		ind       RebootIndicators
+		remaining int
+		want      RebootState
+	}{
+		{RebootIndicators{}, 0, NothingPending},
+		{RebootIndicators{WindowsUpdate: true}, 0, RebootToComplete},
+		{RebootIndicators{ComponentServicing: true, FileRenames: true}, 0, RebootToComplete},
+		{RebootIndicators{AutoUpdate: true}, 2, InstallsPending},
+		{RebootIndicators{InstallerBusy: true}, 0, InstallsPending},
+		{RebootIndicators{}, 1, InstallsPending},
+	} {
+		if got := tt.ind.State(tt.remaining); got != tt.want {
+			t.Errorf("%+v.State(%d) = %s, want %s", tt.ind, tt.remaining, got, tt.want)
+		}
+	}
+}
+
+func TestDegradedFeatures(t *testing.T) {
+	for _, tt := range []struct {
+		build    int
+		degraded bool
+	}{
+		{7601, true},
+		{MinTestedBuild, false},
+		{17763, false},
+		{MaxTestedBuild, false},
+		{22631, true},
+	} {
+		if got := len(DegradedFeatures(tt.build)) > 0; got != tt.degraded {
+			t.Errorf("DegradedFeatures(%d) degraded = %t, want %t", tt.build, got, tt.degraded)
+		}
+	}
+}
+
+func TestHResultString(t *testing.T) {
+	for _, tt := range []struct {
+		in   int
+		want string
+	}{
+		{-2145124329, "0x80240017 (WU_E_NOT_APPLICABLE)"},
+		{-2145124321, "0x8024001F (WU_E_NO_CONNECTION)"},
+		{0x8024001F, "0x8024001F (WU_E_NO_CONNECTION)"},
+		{-2147024891, "0x80070005"},
+	} {
+		if got := HResultString(tt.in); got != tt.want {
+			t.Errorf("HResultString(%d) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGetPropertyRetry(t *testing.T) {
+	defer func(g func(*ole.IDispatch, string, ...interface{}) (*ole.VARIANT, error), s func(time.Duration)) {
+		getProperty, sleep = g, s
+	}(getProperty, sleep)
+	defer SetPropertyRetries(3)
+
+	rejected := ole.NewError(0x80010001)
+	denied := ole.NewError(0x80070005)
+	for _, tt := range []struct {
+		desc      string
+		retries   int
+		failures  []error
+		wantCalls int
+		wantWaits []time.Duration
+		wantErr   bool
+	}{
+		{"transient then success", 3, []error{rejected, rejected}, 3, []time.Duration{50 * time.Millisecond, 100 * time.Millisecond}, false},
+		{"transient exhausts retries", 1, []error{rejected, rejected}, 2, []time.Duration{50 * time.Millisecond}, true},
+		{"not transient", 3, []error{denied}, 1, nil, true},
+		{"retries disabled", 0, []error{rejected}, 1, nil, true},
+	} {
+		SetPropertyRetries(tt.retries)
+		var calls int
+		var waits []time.Duration
+		getProperty = func(*ole.IDispatch, string, ...interface{}) (*ole.VARIANT, error) {
+			calls++
+			if calls <= len(tt.failures) {
+				return nil, tt.failures[calls-1]
+			}
+			return &ole.VARIANT{}, nil
+		}
+		sleep = func(d time.Duration) { waits = append(waits, d) }
+
+		_, err := GetPropertyRetry(nil, "Title")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: GetPropertyRetry() error = %v, want error: %t", tt.desc, err, tt.wantErr)
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("%s: GetPropertyRetry() made %d calls, want %d", tt.desc, calls, tt.wantCalls)
+		}
+		if !reflect.DeepEqual(waits, tt.wantWaits) {
+			t.Errorf("%s: GetPropertyRetry() waited %v, want %v", tt.desc, waits, tt.wantWaits)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows,debug
+
+package cablib
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+)
+
+// Invoke kinds of a FUNCDESC.
+// https://docs.microsoft.com/en-us/windows/win32/api/oaidl/ne-oaidl-invokekind
+const (
+	invokeFunc        = 1
+	invokePropertyGet = 2
+)
+
+// funcDesc mirrors the FUNCDESC structure.
+// https://docs.microsoft.com/en-us/windows/win32/api/oaidl/ns-oaidl-funcdesc
+type funcDesc struct {
+	memid             int32
+	lprgscode         uintptr
+	lprgelemdescParam uintptr
+	funckind          int32
+	invkind           int32
+	callconv          int32
+	cParams           int16
+	cParamsOpt        int16
+	oVft              int16
+	cScodes           int16
+	elemdescFunc      elemDesc
+	wFuncFlags        uint16
+}
+
+type elemDesc struct {
+	lptdesc      uintptr
+	vt           uint16
+	pparamdescex uintptr
+	wParamFlags  uint16
+}
+
+// DumpProperties enumerates the members an IDispatch exposes through its ITypeInfo, mapping each
+// property name to its variant type and each method name to "method". It is intended to help
+// discover which properties of an update or history entry can be surfaced on a given OS build.
+func DumpProperties(d *ole.IDispatch) (map[string]string, error) {
+	ti, err := d.GetTypeInfo()
+	if err != nil {
+		return nil, fmt.Errorf("GetTypeInfo: %v", err)
+	}
+	defer ti.Release()
+
+	attr, err := ti.GetTypeAttr()
+	if err != nil {
+		return nil, fmt.Errorf("GetTypeAttr: %v", err)
+	}
+	defer syscall.Syscall(ti.VTable().ReleaseTypeAttr, 2, uintptr(unsafe.Pointer(ti)), uintptr(unsafe.Pointer(attr)), 0)
+
+	props := make(map[string]string)
+	for i := 0; i < int(attr.CFuncs); i++ {
+		var fd *funcDesc
+		hr, _, _ := syscall.Syscall(ti.VTable().GetFuncDesc, 3, uintptr(unsafe.Pointer(ti)), uintptr(i), uintptr(unsafe.Pointer(&fd)))
+		if hr != S_OK {
+			return props, fmt.Errorf("GetFuncDesc(%d): %v", i, ole.NewError(hr))
+		}
+		name, err := memberName(ti, fd.memid)
+		kind, vt := fd.invkind, fd.elemdescFunc.vt
+		syscall.Syscall(ti.VTable().ReleaseFuncDesc, 2, uintptr(unsafe.Pointer(ti)), uintptr(unsafe.Pointer(fd)), 0)
+		if err != nil {
+			return props, err
+		}
+
+		switch kind {
+		case invokePropertyGet:
+			props[name] = ole.VT(vt).String()
+		case invokeFunc:
+			props[name] = "method"
+		}
+	}
+	return props, nil
+}
+
+func memberName(ti *ole.ITypeInfo, memid int32) (string, error) {
+	var bstr *uint16
+	var n uint32
+	hr, _, _ := syscall.Syscall6(ti.VTable().GetNames, 5, uintptr(unsafe.Pointer(ti)), uintptr(memid), uintptr(unsafe.Pointer(&bstr)), 1, uintptr(unsafe.Pointer(&n)), 0)
+	if hr != S_OK {
+		return "", fmt.Errorf("GetNames(%d): %v", memid, ole.NewError(hr))
+	}
+	if n == 0 {
+		return "", fmt.Errorf("GetNames(%d): no name returned", memid)
+	}
+	defer ole.SysFreeString((*int16)(unsafe.Pointer(bstr)))
+	return ole.BstrToString(bstr), nil
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cablib
+
+import (
+	"fmt"
+
+	"github.com/google/cabbie/errors"
+)
+
+// HResultString formats an HRESULT in the conventional 0x8024xxxx form, followed by its symbolic
+// name when it is a known Windows Update error.
+func HResultString(h int) string {
+	hex := fmt.Sprintf("0x%08X", uint32(h))
+	if name := errors.UpdateError(uint32(h)).ErrorName(); name != "" {
+		return fmt.Sprintf("%s (%s)", hex, name)
+	}
+	return hex
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package cablib
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	currentVersion = `SOFTWARE\Microsoft\Windows NT\CurrentVersion`
+
+	// MinTestedBuild is the oldest OS build Cabbie is tested against (Windows 8.1 and Server 2012 R2).
+	MinTestedBuild = 9600
+	// MaxTestedBuild is the newest OS build Cabbie is tested against (Windows 10 20H2).
+	MaxTestedBuild = 19042
+)
+
+// OSBuild returns the build number of the running OS.
+func OSBuild() (int, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, currentVersion, registry.QUERY_VALUE)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %q: %v", currentVersion, err)
+	}
+	defer k.Close()
+
+	b, _, err := k.GetStringValue("CurrentBuildNumber")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read CurrentBuildNumber: %v", err)
+	}
+	build, err := strconv.Atoi(b)
+	if err != nil {
+		return 0, fmt.Errorf("invalid build number %q: %v", b, err)
+	}
+	return build, nil
+}
+
+// DegradedFeatures returns the features that may not work as expected on build. Builds within the
+// tested range return nil.
+func DegradedFeatures(build int) []string {
+	switch {
+	case build < MinTestedBuild:
+		return []string{
+			"update searches may not support all criteria",
+			"InstallationBehavior and IsBeta may be unavailable",
+			"third-party antivirus detection requires Security Center",
+		}
+	case build > MaxTestedBuild:
+		return []string{
+			"Windows Update Agent COM interfaces are untested and may behave differently",
+			"pending reboot detection may miss new indicators",
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package cablib
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows/registry"
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+const (
+	cbsRebootPending   = `SOFTWARE\Microsoft\Windows\CurrentVersion\Component Based Servicing\RebootPending`
+	wuRebootRequired   = `SOFTWARE\Microsoft\Windows\CurrentVersion\WindowsUpdate\Auto Update\RebootRequired`
+	sessionManager     = `SYSTEM\CurrentControlSet\Control\Session Manager`
+	pendingFileRenames = "PendingFileRenameOperations"
+)
+
+// RebootState classifies what remains to finish installing updates.
+type RebootState int
+
+const (
+	// NothingPending indicates no reboot or further installs are outstanding.
+	NothingPending RebootState = iota
+	// RebootToComplete indicates a reboot alone completes the pending installs.
+	RebootToComplete
+	// InstallsPending indicates further installs are needed, after rebooting if a reboot is also
+	// pending.
+	InstallsPending
+)
+
+func (s RebootState) String() string {
+	switch s {
+	case NothingPending:
+		return "nothing pending"
+	case RebootToComplete:
+		return "reboot to complete"
+	case InstallsPending:
+		return "further installs pending"
+	}
+	return fmt.Sprintf("unknown (%d)", int(s))
+}
+
+// RebootIndicators holds the signals Windows uses to mark a reboot or install as outstanding.
+type RebootIndicators struct {
+	// WindowsUpdate is the RebootRequired status reported by the Windows Update Agent.
+	WindowsUpdate bool
+	// ComponentServicing is set when Component Based Servicing has packages waiting on a reboot.
+	ComponentServicing bool
+	// AutoUpdate is set when Automatic Updates has flagged a required reboot.
+	AutoUpdate bool
+	// FileRenames is set when file operations are queued for the next boot.
+	FileRenames bool
+	// InstallerBusy is set while the Windows Update installer is running an install.
+	InstallerBusy bool
+}
+
+// RebootPending reports whether any indicator requires a reboot.
+func (r RebootIndicators) RebootPending() bool {
+	return r.WindowsUpdate || r.ComponentServicing || r.AutoUpdate || r.FileRenames
+}
+
+// State classifies the indicators along with the number of updates still waiting to be installed.
+// A busy installer always means further installs are pending, as its outcome is not yet known.
+func (r RebootIndicators) State(remaining int) RebootState {
+	switch {
+	case r.InstallerBusy || remaining > 0:
+		return InstallsPending
+	case r.RebootPending():
+		return RebootToComplete
+	}
+	return NothingPending
+}
+
+// PendingReboot reads the reboot indicators from the Windows Update Agent and the registry.
+func PendingReboot() (RebootIndicators, error) {
+	var r RebootIndicators
+	var err error
+	if r.WindowsUpdate, err = rebootRequired(); err != nil {
+		return r, err
+	}
+	if r.InstallerBusy, err = installerBusy(); err != nil {
+		return r, err
+	}
+	if r.ComponentServicing, err = keyExists(cbsRebootPending); err != nil {
+		return r, err
+	}
+	if r.AutoUpdate, err = keyExists(wuRebootRequired); err != nil {
+		return r, err
+	}
+
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, sessionManager, registry.QUERY_VALUE)
+	if err != nil {
+		return r, fmt.Errorf("failed to open %q: %v", sessionManager, err)
+	}
+	defer k.Close()
+	renames, _, err := k.GetStringsValue(pendingFileRenames)
+	if err != nil && err != registry.ErrNotExist {
+		return r, fmt.Errorf("failed to read %s: %v", pendingFileRenames, err)
+	}
+	r.FileRenames = len(renames) > 0
+
+	return r, nil
+}
+
+// installerBusy reports whether the Windows Update installer is running an install or uninstall.
+func installerBusy() (bool, error) {
+	if err := InitializeCOM(); err != nil {
+		return false, err
+	}
+	defer ole.CoUninitialize()
+
+	installer, err := NewCOMObject("Microsoft.Update.Installer")
+	if err != nil {
+		return false, err
+	}
+	defer installer.Release()
+
+	b, err := oleutil.GetProperty(installer, "IsBusy")
+	if err != nil {
+		return false, fmt.Errorf("failed to get IsBusy property: %v", err)
+	}
+	defer b.Clear()
+
+	busy, ok := b.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("IsBusy property is %T, not a bool", b.Value())
+	}
+	return busy, nil
+}
+
+func keyExists(path string) (bool, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err == registry.ErrNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open %q: %v", path, err)
+	}
+	k.Close()
+	return true, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cablib
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// transientHResults are RPC failures of the Windows Update Agent that usually succeed when retried.
+var transientHResults = map[uint32]bool{
+	0x80010001: true, // RPC_E_CALL_REJECTED
+	0x8001010A: true, // RPC_E_SERVERCALL_RETRYLATER
+	0x800706BA: true, // RPC_S_SERVER_UNAVAILABLE
+	0x800706BB: true, // RPC_S_SERVER_TOO_BUSY
+	0x800706BE: true, // RPC_S_CALL_FAILED
+}
+
+var (
+	propertyRetries int32 = 3
+	// propertyBackoff is the wait before the first retry, doubling after each attempt.
+	propertyBackoff = 50 * time.Millisecond
+
+	getProperty = oleutil.GetProperty
+	sleep       = time.Sleep
+)
+
+// SetPropertyRetries sets how many times GetPropertyRetry retries a transient failure. Values below
+// zero are treated as zero.
+func SetPropertyRetries(n int) {
+	if n < 0 {
+		n = 0
+	}
+	atomic.StoreInt32(&propertyRetries, int32(n))
+}
+
+// GetPropertyRetry gets a property of d like oleutil.GetProperty, retrying with backoff when the
+// call fails with a transient RPC error. Other errors are returned immediately.
+func GetPropertyRetry(d *ole.IDispatch, name string, args ...interface{}) (*ole.VARIANT, error) {
+	retries := int(atomic.LoadInt32(&propertyRetries))
+	wait := propertyBackoff
+	for attempt := 0; ; attempt++ {
+		v, err := getProperty(d, name, args...)
+		if err == nil {
+			return v, nil
+		}
+		if !transient(err) || attempt >= retries {
+			return v, err
+		}
+		sleep(wait)
+		wait *= 2
+	}
+}
+
+// transient reports whether err is a transient RPC failure.
+func transient(err error) bool {
+	oe, ok := err.(*ole.OleError)
+	return ok && transientHResults[uint32(oe.Code())]
+}
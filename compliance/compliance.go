@@ -0,0 +1,228 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+// Package compliance reports how closely a device matches the updates that apply to it.
+package compliance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/cabbie/search"
+	"github.com/google/cabbie/updatecollection"
+	"github.com/google/cabbie/updates"
+)
+
+const (
+	// ApplicableSearch queries for every update assigned to the device, installed or not.
+	ApplicableSearch = "IsInstalled=1 and DeploymentAction='Installation' or IsInstalled=0 and DeploymentAction='Installation'"
+	// PendingSearch queries for applicable updates that are not yet installed.
+	PendingSearch = "IsInstalled=0 and DeploymentAction='Installation'"
+	// BaselineSearch queries for every update known to the device, installed or not.
+	BaselineSearch = "IsInstalled=1 or IsInstalled=0"
+)
+
+// BaselineReport describes how the installed updates compare to a baseline of required KBs. KBs are
+// reported without a "KB" prefix.
+type BaselineReport struct {
+	// Installed are baseline KBs with an installed update.
+	Installed []string
+	// MissingApplicable are baseline KBs offered to the device but not installed.
+	MissingApplicable []string
+	// MissingNotApplicable are baseline KBs the device does not know about, usually because they do
+	// not apply to it.
+	MissingNotApplicable []string
+	// Superseded maps baseline KBs that are not installed to the installed KBs superseding them,
+	// which satisfy the baseline.
+	Superseded map[string][]string
+}
+
+// Satisfied reports whether every applicable baseline KB is installed or superseded.
+func (r BaselineReport) Satisfied() bool {
+	return len(r.MissingApplicable) == 0
+}
+
+// AgainstBaseline compares the updates known to the device, including superseded ones, to the
+// baseline KBs. An installed update that supersedes a baseline KB satisfies it.
+func AgainstBaseline(s *search.Searcher, baseline []string) (BaselineReport, error) {
+	sup := s.IncludePotentiallySupersededUpdates
+	s.IncludePotentiallySupersededUpdates = true
+	defer func() { s.IncludePotentiallySupersededUpdates = sup }()
+
+	uc, err := query(s, BaselineSearch)
+	if err != nil {
+		return BaselineReport{}, err
+	}
+	defer uc.Close()
+
+	return againstBaseline(uc.Updates, baseline), nil
+}
+
+func againstBaseline(ups []*updates.Update, baseline []string) BaselineReport {
+	// supersededBy maps an UpdateID to the KBs of installed updates superseding it.
+	supersededBy := make(map[string][]string)
+	installed := make(map[string]bool)
+	for _, u := range ups {
+		if !u.IsInstalled {
+			continue
+		}
+		for _, kb := range u.KBArticleIDs {
+			installed[normalizeKB(kb)] = true
+		}
+		for _, id := range u.SupersededUpdateIDs {
+			supersededBy[id] = append(supersededBy[id], u.KBArticleIDs...)
+		}
+	}
+
+	r := BaselineReport{Superseded: make(map[string][]string)}
+	seen := make(map[string]bool)
+	for _, b := range baseline {
+		kb := normalizeKB(b)
+		if kb == "" || seen[kb] {
+			continue
+		}
+		seen[kb] = true
+		if installed[kb] {
+			r.Installed = append(r.Installed, kb)
+			continue
+		}
+
+		var known bool
+		var by []string
+		for _, u := range ups {
+			if !hasKB(u, kb) {
+				continue
+			}
+			known = true
+			by = append(by, supersededBy[u.Identity.UpdateID]...)
+		}
+		switch {
+		case len(by) > 0:
+			r.Superseded[kb] = dedupe(by)
+		case known:
+			r.MissingApplicable = append(r.MissingApplicable, kb)
+		default:
+			r.MissingNotApplicable = append(r.MissingNotApplicable, kb)
+		}
+	}
+	return r
+}
+
+func normalizeKB(kb string) string {
+	kb = strings.TrimSpace(kb)
+	if len(kb) > 2 && strings.EqualFold(kb[:2], "KB") {
+		kb = kb[2:]
+	}
+	return kb
+}
+
+func hasKB(u *updates.Update, kb string) bool {
+	for _, k := range u.KBArticleIDs {
+		if normalizeKB(k) == kb {
+			return true
+		}
+	}
+	return false
+}
+
+func dedupe(s []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, v := range s {
+		v = normalizeKB(v)
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Applicable returns every update assigned to the device, installed or not, from which both
+// Percentage and OldestPending can be derived. The caller closes the collection.
+func Applicable(s *search.Searcher) (*updatecollection.Collection, error) {
+	return query(s, ApplicableSearch)
+}
+
+// Percentage returns the percent of the applicable updates in ups that are installed along with the
+// number of installed and total applicable updates. Definition updates can be excluded as they are
+// released several times a day and skew the result. A device with no applicable updates is 100%
+// compliant.
+func Percentage(ups []*updates.Update, excludeDefinitions bool) (float64, int, int) {
+	var installed, total int
+	for _, u := range ups {
+		if excludeDefinitions && IsDefinition(u.Categories) {
+			continue
+		}
+		total++
+		if u.IsInstalled {
+			installed++
+		}
+	}
+
+	if total == 0 {
+		return 100, 0, 0
+	}
+	return float64(installed) / float64(total) * 100, installed, total
+}
+
+// OldestPending returns how long the oldest uninstalled update in ups has been available as of now,
+// based on its LastDeploymentChangeTime, along with its identity. Definition updates can be excluded.
+// A zero age is returned when no updates are pending.
+func OldestPending(ups []*updates.Update, excludeDefinitions bool, now time.Time) (time.Duration, updates.Identity) {
+	var o *updates.Update
+	for _, u := range ups {
+		if u.IsInstalled || u.LastDeploymentChangeTime.IsZero() {
+			continue
+		}
+		if excludeDefinitions && IsDefinition(u.Categories) {
+			continue
+		}
+		if o == nil || u.LastDeploymentChangeTime.Before(o.LastDeploymentChangeTime) {
+			o = u
+		}
+	}
+
+	if o == nil {
+		return 0, updates.Identity{}
+	}
+	return now.Sub(o.LastDeploymentChangeTime), o.Identity
+}
+
+// IsDefinition reports whether the categories include definition updates, which antivirus products
+// release several times a day.
+func IsDefinition(categories []updates.Category) bool {
+	for _, c := range categories {
+		if strings.EqualFold(c.CategoryID, string(search.DefinitionUpdates)) || c.Name == "Definition Updates" {
+			return true
+		}
+	}
+	return false
+}
+
+// query runs a search using the supplied criteria, leaving the searcher's own criteria untouched.
+func query(s *search.Searcher, criteria string) (*updatecollection.Collection, error) {
+	c := s.Criteria
+	s.Criteria = criteria
+	defer func() { s.Criteria = c }()
+
+	uc, err := s.QueryUpdates()
+	if err != nil {
+		return nil, fmt.Errorf("error querying updates with criteria %q: %v", criteria, err)
+	}
+	return uc, nil
+}
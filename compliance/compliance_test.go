@@ -0,0 +1,103 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package compliance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/cabbie/updates"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	definition = &updates.Update{
+		Title:      "Definition update",
+		Categories: []updates.Category{{Name: "Definition Updates", CategoryID: "e0789628-ce08-4437-be74-2495b842f43b"}},
+	}
+	installed = &updates.Update{Title: "Installed update", IsInstalled: true}
+	pending   = &updates.Update{Title: "Pending update"}
+)
+
+func TestPercentage(t *testing.T) {
+	for _, tt := range []struct {
+		in               []*updates.Update
+		excludeDefs      bool
+		out              float64
+		installed, total int
+	}{
+		{nil, false, 100, 0, 0},
+		{[]*updates.Update{definition}, true, 100, 0, 0},
+		{[]*updates.Update{installed, pending}, false, 50, 1, 2},
+		{[]*updates.Update{installed, pending, pending, definition}, false, 25, 1, 4},
+		{[]*updates.Update{installed, pending, definition}, true, 50, 1, 2},
+		{[]*updates.Update{installed}, false, 100, 1, 1},
+	} {
+		o, i, c := Percentage(tt.in, tt.excludeDefs)
+		if o != tt.out || i != tt.installed || c != tt.total {
+			t.Errorf("Percentage(%v, %t) = %v, %d, %d, want %v, %d, %d", tt.in, tt.excludeDefs, o, i, c, tt.out, tt.installed, tt.total)
+		}
+	}
+}
+
+func TestOldestPending(t *testing.T) {
+	now := time.Date(2020, 10, 15, 0, 0, 0, 0, time.UTC)
+	old := &updates.Update{Identity: updates.Identity{UpdateID: "old"}, LastDeploymentChangeTime: now.Add(-72 * time.Hour)}
+	recent := &updates.Update{Identity: updates.Identity{UpdateID: "recent"}, LastDeploymentChangeTime: now.Add(-24 * time.Hour)}
+	def := &updates.Update{
+		Identity:                 updates.Identity{UpdateID: "def"},
+		Categories:               definition.Categories,
+		LastDeploymentChangeTime: now.Add(-96 * time.Hour),
+	}
+	for _, tt := range []struct {
+		in          []*updates.Update
+		excludeDefs bool
+		age         time.Duration
+		id          string
+	}{
+		{nil, false, 0, ""},
+		{[]*updates.Update{recent, old}, false, 72 * time.Hour, "old"},
+		{[]*updates.Update{recent, old, def}, false, 96 * time.Hour, "def"},
+		{[]*updates.Update{recent, old, def}, true, 72 * time.Hour, "old"},
+		{[]*updates.Update{def}, true, 0, ""},
+	} {
+		age, id := OldestPending(tt.in, tt.excludeDefs, now)
+		if age != tt.age || id.UpdateID != tt.id {
+			t.Errorf("OldestPending(%v, %t) = %v, %q, want %v, %q", tt.in, tt.excludeDefs, age, id.UpdateID, tt.age, tt.id)
+		}
+	}
+}
+
+func TestAgainstBaseline(t *testing.T) {
+	ups := []*updates.Update{
+		{Identity: updates.Identity{UpdateID: "ssu"}, KBArticleIDs: []string{"100"}, IsInstalled: true},
+		{Identity: updates.Identity{UpdateID: "old-cu"}, KBArticleIDs: []string{"200"}},
+		{Identity: updates.Identity{UpdateID: "new-cu"}, KBArticleIDs: []string{"300"}, IsInstalled: true, SupersededUpdateIDs: []string{"old-cu"}},
+		{Identity: updates.Identity{UpdateID: "net"}, KBArticleIDs: []string{"400"}},
+	}
+	got := againstBaseline(ups, []string{"KB100", "200", "kb400", "500", "100", ""})
+	want := BaselineReport{
+		Installed:            []string{"100"},
+		MissingApplicable:    []string{"400"},
+		MissingNotApplicable: []string{"500"},
+		Superseded:           map[string][]string{"200": {"300"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("againstBaseline() returned diff (-want +got):\n%s", diff)
+	}
+	if got.Satisfied() {
+		t.Errorf("Satisfied() = true with missing KBs %v", got.MissingApplicable)
+	}
+}
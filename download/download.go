@@ -16,15 +16,19 @@
 package download
 
 import (
+	"context"
 	"fmt"
 
-	"github.com/google/cabbie/errors"
+	"github.com/google/cabbie/cablib"
+	"github.com/google/cabbie/logging"
 	"github.com/google/cabbie/session"
 	"github.com/google/cabbie/updatecollection"
 	"github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
 )
 
+var log = logging.For("download")
+
 // Downloader represents an update download interface.
 // https://docs.microsoft.com/en-us/windows/desktop/api/wuapi/nn-wuapi-iupdatedownloader
 type Downloader struct {
@@ -48,11 +52,33 @@ func NewDownloader(us *session.UpdateSession, uc *updatecollection.Collection) (
 
 // Download will download the requested updates.
 func (d *Downloader) Download() error {
-	r, err := oleutil.CallMethod(d.IUpdateDownloader, "Download")
-	d.IDownloadResult = r.ToIDispatch()
+	return d.DownloadContext(context.Background())
+}
+
+// DownloadContext downloads the requested updates, aborting the download if the context is
+// cancelled or its deadline passes before it completes.
+func (d *Downloader) DownloadContext(ctx context.Context) error {
+	if err := cablib.CheckWritable("download"); err != nil {
+		return err
+	}
+	j, err := oleutil.CallMethod(d.IUpdateDownloader, "BeginDownload", nil, nil, nil)
 	if err != nil {
-		return fmt.Errorf("download error: [%s] [%v]", errors.UpdateError(r.Val), err)
+		return fmt.Errorf("download error: %v", err)
 	}
+	job := j.ToIDispatch()
+	defer job.Release()
+	defer oleutil.CallMethod(job, "CleanUp")
+
+	log.Debug(2, "Started download job")
+	if err := cablib.WaitForJob(ctx, job, "download"); err != nil {
+		return fmt.Errorf("download error: %v", err)
+	}
+
+	r, err := oleutil.CallMethod(d.IUpdateDownloader, "EndDownload", job)
+	if err != nil {
+		return fmt.Errorf("download error: %v", err)
+	}
+	d.IDownloadResult = r.ToIDispatch()
 	return nil
 }
 
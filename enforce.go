@@ -18,6 +18,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -70,6 +71,9 @@ func allEnforcements() (enforcement, error) {
 	}
 	var e enforcement
 	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
 		p := filepath.Join(enforceDir, f.Name())
 		kbs, err := enforcements(p)
 		if err != nil {
@@ -98,13 +102,13 @@ func (e *enforcement) dedupe() {
 	e.Required = u
 }
 
-func (e *enforcement) install() error {
+func (e *enforcement) install(ctx context.Context) error {
 	if len(e.Required) == 0 {
 		elog.Info(0002, fmt.Sprintf("No enforced updates defined."))
 		return nil
 	}
 	i := installCmd{kbs: strings.Join(e.Required, ",")}
-	return i.installUpdates()
+	return i.installUpdates(ctx)
 }
 
 // Filesystem watcher for required updates. This is meant to install required updates as soon as they are configured.
@@ -133,6 +137,11 @@ func runEnforcementWatcher(file chan<- string) error {
 
 	for {
 		evt := <-fsw.Events
+		// Only enforcement files are of interest; changes within subdirectories such as Cabbie's
+		// state directory are also reported against the subdirectory itself.
+		if filepath.Ext(evt.Name) != ".json" {
+			continue
+		}
 		if cablib.SliceContains([]fsnotify.Op{fsnotify.Write, fsnotify.Create}, evt.Op) {
 			file <- evt.Name
 		}
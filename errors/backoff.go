@@ -0,0 +1,68 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package errors
+
+import (
+	"fmt"
+	"time"
+)
+
+// backoffs are the HResults returned when an update server asks clients to back off, along with the
+// initial delay to honor. The Windows Update Agent does not expose any Retry-After duration the
+// server sends, so these are conservative defaults.
+var backoffs = map[UpdateError]time.Duration{
+	WU_E_PT_HTTP_STATUS_SERVICE_UNAVAIL: 15 * time.Minute,
+	WU_E_PT_SOAPCLIENT_SERVER:           15 * time.Minute,
+	WU_E_PT_SOAP_SERVER:                 30 * time.Minute,
+	WU_E_PT_EXCEEDED_MAX_SERVER_TRIPS:   time.Hour,
+}
+
+// maxBackoff caps the delay when a server keeps asking clients to back off.
+const maxBackoff = 4 * time.Hour
+
+// BackoffError is returned when an update server asks clients to back off before retrying.
+type BackoffError struct {
+	Code UpdateError
+	// RetryAfter is how long to wait from Until before contacting the server again.
+	RetryAfter time.Duration
+	// Until is when the server may be contacted again.
+	Until time.Time
+	// Pending is set when the operation was not attempted because an earlier backoff is still in
+	// effect.
+	Pending bool
+}
+
+func (e *BackoffError) Error() string {
+	if e.Pending {
+		return fmt.Sprintf("server requested backoff %s, not retrying until %s", e.Code, e.Until.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("server requested backoff %s, retrying after %s", e.Code, e.RetryAfter)
+}
+
+// Backoff returns the delay to honor for ue, doubled for each consecutive backoff that preceded it
+// up to a maximum. ok is false when ue does not ask clients to back off.
+func Backoff(ue UpdateError, consecutive int) (d time.Duration, ok bool) {
+	d, ok = backoffs[ue]
+	if !ok {
+		return 0, false
+	}
+	for i := 0; i < consecutive && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d, true
+}
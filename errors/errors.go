@@ -107,6 +107,9 @@ const (
 	WU_E_WINHTTP_INVALID_FILE             UpdateError = 0x80240038
 	WU_E_DS_UNKNOWNSERVICE                UpdateError = 0x80248014
 	WU_E_PT_ECP_SUCCEEDED_WITH_ERRORS     UpdateError = 0x8024402F
+	WU_E_PT_SOAPCLIENT_SERVER             UpdateError = 0x80244007
+	WU_E_PT_SOAP_SERVER                   UpdateError = 0x8024400F
+	WU_E_PT_EXCEEDED_MAX_SERVER_TRIPS     UpdateError = 0x80244010
 	WU_E_PT_HTTP_STATUS_BAD_REQUEST       UpdateError = 0x80244016
 	WU_E_PT_HTTP_STATUS_DENIED            UpdateError = 0x80244017
 	WU_E_PT_HTTP_STATUS_FORBIDDEN         UpdateError = 0x80244018
@@ -127,6 +130,7 @@ const (
 	TRY_AGAIN_ERROR                       UpdateError = 0x80240438
 	TIME_VERIFICATION                     UpdateError = 0x80072F8F
 	EXCEPTION_OCCURRED                    UpdateError = 0x8024500C
+	ERROR_SUCCESS_REBOOT_REQUIRED         UpdateError = 0x80070BC2
 )
 
 // ErrorDesc gets the help string related to a hex error.
@@ -316,6 +320,12 @@ func (ue UpdateError) ErrorDesc() string {
 		return `Same as HTTP status 409 – The request was not completed due to a conflict with the current state of the resource.`
 	case WU_E_PT_HTTP_STATUS_GONE:
 		return `Same as HTTP status 410 – Requested resource is no longer available at the server.`
+	case WU_E_PT_SOAPCLIENT_SERVER:
+		return `SOAP client failed because of a server error.`
+	case WU_E_PT_SOAP_SERVER:
+		return `The message was OK but server could not process at the moment. Same as SOAP fault code "Server".`
+	case WU_E_PT_EXCEEDED_MAX_SERVER_TRIPS:
+		return `The number of round trips to the server exceeded the maximum limit.`
 	case WU_E_PT_HTTP_STATUS_SERVER_ERROR:
 		return `Same as HTTP status 500 – An error internal to the server prevented fulfilling the request.`
 	case WU_E_PT_HTTP_STATUS_NOT_SUPPORTED:
@@ -338,6 +348,8 @@ func (ue UpdateError) ErrorDesc() string {
 		return `Incorrect date, time and timezone settings on the computer.`
 	case EXCEPTION_OCCURRED:
 		return `General COM exception occurred, usually caused by a misconfigured registry setting.`
+	case ERROR_SUCCESS_REBOOT_REQUIRED:
+		return `The requested operation is successful. Changes will not be effective until the system is rebooted.`
 	default:
 		return fmt.Sprintf("Unknown error: 0x%X", int64(ue))
 	}
@@ -530,6 +542,12 @@ func (ue UpdateError) ErrorName() string {
 		return `WU_E_PT_HTTP_STATUS_CONFLICT`
 	case WU_E_PT_HTTP_STATUS_GONE:
 		return `WU_E_PT_HTTP_STATUS_GONE`
+	case WU_E_PT_SOAPCLIENT_SERVER:
+		return `WU_E_PT_SOAPCLIENT_SERVER`
+	case WU_E_PT_SOAP_SERVER:
+		return `WU_E_PT_SOAP_SERVER`
+	case WU_E_PT_EXCEEDED_MAX_SERVER_TRIPS:
+		return `WU_E_PT_EXCEEDED_MAX_SERVER_TRIPS`
 	case WU_E_PT_HTTP_STATUS_SERVER_ERROR:
 		return `WU_E_PT_HTTP_STATUS_SERVER_ERROR`
 	case WU_E_PT_HTTP_STATUS_NOT_SUPPORTED:
@@ -552,6 +570,8 @@ func (ue UpdateError) ErrorName() string {
 		return `TIME_VERIFICATION`
 	case EXCEPTION_OCCURRED:
 		return `EXCEPTION_OCCURRED`
+	case ERROR_SUCCESS_REBOOT_REQUIRED:
+		return `ERROR_SUCCESS_REBOOT_REQUIRED`
 	default:
 		return ``
 	}
@@ -16,6 +16,7 @@ package errors
 
 import (
 	"testing"
+	"time"
 )
 
 func TestDesc(t *testing.T) {
@@ -68,3 +69,22 @@ func TestString(t *testing.T) {
 		}
 	}
 }
+
+func TestBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		in          UpdateError
+		consecutive int
+		want        time.Duration
+		ok          bool
+	}{
+		{WU_E_PT_HTTP_STATUS_SERVICE_UNAVAIL, 0, 15 * time.Minute, true},
+		{WU_E_PT_HTTP_STATUS_SERVICE_UNAVAIL, 2, time.Hour, true},
+		{WU_E_PT_EXCEEDED_MAX_SERVER_TRIPS, 10, 4 * time.Hour, true},
+		{WU_E_PT_HTTP_STATUS_NOT_FOUND, 0, 0, false},
+	} {
+		got, ok := Backoff(tt.in, tt.consecutive)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Backoff(%s, %d) = %v, %t, want %v, %t", tt.in.ErrorName(), tt.consecutive, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"flag"
+	"github.com/google/cabbie/updates"
+	"github.com/google/subcommands"
+)
+
+// Available flags
+type eulaCmd struct {
+	install installCmd
+	accept  bool
+}
+
+func (eulaCmd) Name() string { return "eula" }
+func (eulaCmd) Synopsis() string {
+	return "List updates planned for install whose EULA has not been accepted."
+}
+func (eulaCmd) Usage() string {
+	return fmt.Sprintf("%s eula [--drivers | --virus_def | --kbs=\"<KBNumber>\" | --bulletins=\"<BulletinID>\"] [--beta] [--accept]\n", filepath.Base(os.Args[0]))
+}
+func (c *eulaCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.install.drivers, "drivers", false, "Check available drivers.")
+	f.BoolVar(&c.install.virusDef, "virus_def", false, "Check virus definitions.")
+	f.BoolVar(&c.install.deadlineOnly, "deadlineOnly", false, fmt.Sprintf("Check available updates older than %d days", config.Deadline))
+	f.StringVar(&c.install.kbs, "kbs", "", "Comma separated string of KB numbers in the form of 1234567.")
+	f.StringVar(&c.install.bulletins, "bulletins", "", "Comma separated string of security bulletin IDs in the form of MS17-010.")
+	f.BoolVar(&c.install.beta, "beta", false, "Include beta updates, which are excluded by default.")
+	f.BoolVar(&c.accept, "accept", false, "Accept the EULAs that have not been accepted.")
+}
+
+func (c eulaCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	pending, err := c.check(ctx)
+	if err != nil {
+		fmt.Printf("Failed to check update EULAs: %v\n", err)
+		elog.Error(117, fmt.Sprintf("Failed to check update EULAs: %v", err))
+		return subcommands.ExitFailure
+	}
+	if len(pending) == 0 {
+		fmt.Println("All updates planned for install have accepted EULAs.")
+		return subcommands.ExitSuccess
+	}
+
+	rc := subcommands.ExitSuccess
+	fmt.Printf("%d updates planned for install have not accepted their EULA:\n", len(pending))
+	for _, u := range pending {
+		switch {
+		case !c.accept:
+			fmt.Printf("  %s\n", u.Title)
+			rc = subcommands.ExitFailure
+		case u.EulaAccepted:
+			fmt.Printf("  %s (accepted)\n", u.Title)
+		default:
+			fmt.Printf("  %s (failed to accept)\n", u.Title)
+			rc = subcommands.ExitFailure
+		}
+	}
+	return rc
+}
+
+// check searches for the updates an install with the same flags would select and returns those with
+// EULAs that have not been accepted, accepting them first when requested.
+func (c eulaCmd) check(ctx context.Context) ([]*updates.Update, error) {
+	criteria, rc := c.install.criteria()
+	uc, err := searchUpdates(ctx, criteria, searchOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+
+	pending := unacceptedEulas(c.install.selectUpdates(uc.Updates, rc))
+	if !c.accept {
+		return pending, nil
+	}
+	for _, u := range pending {
+		installLog.Info(002, fmt.Sprintf("Accepting EULA for update: %s", u.Title))
+		if err := u.AcceptEula(); err != nil {
+			installLog.Error(202, fmt.Sprintf("Failed to accept EULA for update %s:\n%s", u.Title, err))
+		}
+	}
+	return pending, nil
+}
+
+// unacceptedEulas returns the updates whose EULA has not been accepted.
+func unacceptedEulas(ups []*updates.Update) []*updates.Update {
+	var pending []*updates.Update
+	for _, u := range ups {
+		if !u.EulaAccepted {
+			pending = append(pending, u)
+		}
+	}
+	return pending
+}
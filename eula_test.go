@@ -0,0 +1,31 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"testing"
+
+	"github.com/google/cabbie/updates"
+)
+
+func TestUnacceptedEulas(t *testing.T) {
+	ups := []*updates.Update{
+		{Title: "accepted", EulaAccepted: true},
+		{Title: "pending"},
+	}
+	got := unacceptedEulas(ups)
+	if len(got) != 1 || got[0].Title != "pending" {
+		t.Errorf("unacceptedEulas() = %v, want [pending]", got)
+	}
+}
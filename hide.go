@@ -20,9 +20,6 @@ import (
 	"path/filepath"
 
 	"flag"
-	"github.com/google/cabbie/search"
-	"github.com/google/cabbie/session"
-	"github.com/google/cabbie/updatecollection"
 	"github.com/google/subcommands"
 )
 
@@ -43,7 +40,7 @@ func (c *hideCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.unhide, "unhide", false, "mark a hidden update as visible.")
 }
 
-func (c hideCmd) Execute(_ context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (c hideCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 	kbs := NewKBSet(c.kbs)
 
 	if kbs.Size() < 1 {
@@ -52,40 +49,22 @@ func (c hideCmd) Execute(_ context.Context, flags *flag.FlagSet, _ ...interface{
 	}
 
 	if c.unhide {
-		if err := unhide(kbs); err != nil {
+		if err := unhide(ctx, kbs); err != nil {
 			fmt.Println(err)
 			elog.Error(112, fmt.Sprintf("Error unhiding an update: %v", err))
 		}
 		return subcommands.ExitSuccess
 	}
 
-	if err := hide(kbs); err != nil {
+	if err := hide(ctx, kbs); err != nil {
 		fmt.Println(err)
 	}
 	return subcommands.ExitSuccess
 }
 
-// TODO: Turn into shared function that can be used by multiple actions
-func findUpdates(criteria string) (*updatecollection.Collection, error) {
-	// Start Windows update session
-	s, err := session.New()
-	if err != nil {
-		return nil, err
-	}
-	defer s.Close()
-
-	q, err := search.NewSearcher(s, criteria, config.WSUSServers, config.EnableThirdParty)
-	if err != nil {
-		return nil, err
-	}
-	defer q.Close()
-
-	return q.QueryUpdates()
-}
-
-func unhide(kbs KBSet) error {
+func unhide(ctx context.Context, kbs KBSet) error {
 	// Find hidden updates.
-	uc, err := findUpdates("IsHidden=1")
+	uc, err := searchUpdates(ctx, "IsHidden=1", searchOptions{})
 	if err != nil {
 		return err
 	}
@@ -103,9 +82,9 @@ func unhide(kbs KBSet) error {
 	return nil
 }
 
-func hide(kbs KBSet) error {
+func hide(ctx context.Context, kbs KBSet) error {
 	// Find non-hidden updates that are installed or not installed.
-	uc, err := findUpdates("IsHidden=0 and IsInstalled=0 or IsHidden=0 and IsInstalled=1")
+	uc, err := searchUpdates(ctx, "IsHidden=0 and IsInstalled=0 or IsHidden=0 and IsInstalled=1", searchOptions{})
 	if err != nil {
 		return err
 	}
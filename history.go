@@ -15,45 +15,164 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"text/tabwriter"
+	"time"
 
 	"flag"
 	"github.com/google/cabbie/search"
 	"github.com/google/cabbie/session"
 	"github.com/google/cabbie/updatehistory"
+	"github.com/google/cabbie/updates"
 	"github.com/google/subcommands"
 )
 
 // Available flags
 type historyCmd struct {
+	details, noColor, utc bool
+	failed, json, latest  bool
+	csv                   bool
+	activity, days        int
+	kb                    string
+	interval              time.Duration
 }
 
 func (historyCmd) Name() string     { return "history" }
 func (historyCmd) Synopsis() string { return "Get a list of all the installed updates on the device." }
 func (historyCmd) Usage() string {
-	return fmt.Sprintf("%s history\n", filepath.Base(os.Args[0]))
+	return fmt.Sprintf("%s history [--details | --json | --csv] [--no-color] [--failed] [--latest] [--kb=<KBNumber>] [--days=<Days>] [--activity=<Days> [--interval=<Duration>] [--utc]]\n", filepath.Base(os.Args[0]))
 
 }
-func (c *historyCmd) SetFlags(f *flag.FlagSet) {}
+func (c *historyCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.details, "details", false, "Print every field of each history entry instead of a table.")
+	f.BoolVar(&c.csv, "csv", false, "Print the history entries as CSV instead of a table.")
+	f.BoolVar(&c.json, "json", false, "Print the history entries and run metadata as JSON instead of a table.")
+	f.BoolVar(&c.noColor, "no-color", false, "Do not color the history table. Colors are also disabled by setting NO_COLOR.")
+	f.BoolVar(&c.failed, "failed", false, "Only list entries whose operation failed or was aborted.")
+	f.BoolVar(&c.latest, "latest", false, "Only list the most recent entry of each update.")
+	f.StringVar(&c.kb, "kb", "", "Only list entries for the KB, which is parsed from the entry title.")
+	f.IntVar(&c.days, "days", 0, "Only list entries recorded in the last number of days.")
+	f.IntVar(&c.activity, "activity", 0, "Print the number of successful installs and uninstalls over this many days instead of the history.")
+	f.DurationVar(&c.interval, "interval", 24*time.Hour, "Interval of each activity count.")
+	f.BoolVar(&c.utc, "utc", false, "Align activity intervals to UTC instead of local time.")
+}
 
-func (c *historyCmd) Execute(_ context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
-	rc := subcommands.ExitSuccess
-	h, err := history()
+func (c *historyCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	h, err := history(ctx, c.filter(updatehistory.Now()))
 	if err != nil {
 		fmt.Printf("Failed to get update history: %s", err)
-		elog.Error(111, fmt.Sprintf("Failed to get Update history: %s", err))
-		rc = subcommands.ExitFailure
+		historyLog.Error(111, fmt.Sprintf("Failed to get Update history: %s", err))
+		return subcommands.ExitFailure
 	}
 	defer h.Close()
-	for _, e := range h.Entries {
-		fmt.Printf("Installed update:\n%v\n\n", e)
+	if err := h.Err(); err != nil {
+		historyLog.Warning(111, fmt.Sprintf("Skipped %d history entries that could not be read: %v", len(h.Errors), err))
+	}
+
+	if c.activity > 0 {
+		loc := time.Local
+		if c.utc {
+			loc = time.UTC
+		}
+		buckets := updatehistory.Activity(h.Entries, updatehistory.Now(), time.Duration(c.activity)*24*time.Hour, c.interval, loc)
+		if len(buckets) == 0 {
+			fmt.Printf("%s\nUsage: %s\n", c.Synopsis(), c.Usage())
+			return subcommands.ExitUsageError
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "Start\tInstalls\tUninstalls")
+		for _, b := range buckets {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", b.Start.Format("2006-01-02 15:04 MST"), b.Installs, b.Uninstalls)
+		}
+		w.Flush()
+		return subcommands.ExitSuccess
+	}
+	h.SortByDate(false)
+	// Each filter narrows the entries kept by the previous one.
+	entries := h.Entries
+	if c.latest {
+		entries = (&updatehistory.History{Entries: entries}).Latest()
+	}
+	if c.kb != "" {
+		entries = (&updatehistory.History{Entries: entries}).ByKB(c.kb)
+	}
+	if c.failed {
+		entries = (&updatehistory.History{Entries: entries}).Failed()
+	}
+	if c.json {
+		b, err := historyJSON(entries, meta.clone())
+		if err != nil {
+			fmt.Printf("Failed to encode update history: %v\n", err)
+			historyLog.Error(111, fmt.Sprintf("Failed to encode update history: %v", err))
+			return subcommands.ExitFailure
+		}
+		fmt.Println(string(b))
+		return subcommands.ExitSuccess
+	}
+	if c.csv {
+		if err := (&updatehistory.History{Entries: entries}).WriteCSV(os.Stdout); err != nil {
+			fmt.Printf("Failed to write update history: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+	if c.details {
+		for _, e := range entries {
+			fmt.Printf("Installed update:\n%v\n\n", e)
+		}
+		return subcommands.ExitSuccess
+	}
+	ids := make([]updates.Identity, len(entries))
+	for i, e := range entries {
+		ids[i] = e.UpdateIdentity
+	}
+	kbs, err := resolveKBs(ctx, ids)
+	if err != nil {
+		historyLog.Warning(111, fmt.Sprintf("Failed to resolve KBs of history entries: %v", err))
+	}
+	withKBs(entries, kbs)
+	if err := (&updatehistory.History{Entries: entries}).WriteTable(os.Stdout, updatehistory.ColorEnabled(os.Stdout, c.noColor)); err != nil {
+		fmt.Printf("Failed to write update history: %v\n", err)
+		return subcommands.ExitFailure
 	}
-	return rc
+	return subcommands.ExitSuccess
+}
+
+// historyJSON returns entries as indented JSON together with the metadata m of the run that
+// exported them, which is left out when empty.
+func historyJSON(entries []*updatehistory.Entry, m runMeta) ([]byte, error) {
+	return json.MarshalIndent(struct {
+		Meta    runMeta                `json:"meta,omitempty"`
+		Entries *updatehistory.History `json:"entries"`
+	}{m, &updatehistory.History{Entries: entries}}, "", "  ")
 }
 
-func history() (*updatehistory.History, error) {
+// withKBs sets the KBs resolved by search, keyed by UpdateID, on entries. Entries the search did not
+// resolve keep the KBs parsed from their Title.
+func withKBs(entries []*updatehistory.Entry, kbs map[string][]string) {
+	for _, e := range entries {
+		if ids, ok := kbs[e.UpdateIdentity.UpdateID]; ok {
+			e.KBArticleIDs = ids
+		}
+	}
+}
+
+// filter returns the range of entries the command reports on, or nil for the whole history.
+func (c *historyCmd) filter(now time.Time) *updatehistory.Filter {
+	switch {
+	case c.activity > 0:
+		// The first bucket starts at the beginning of its interval, which can precede the window.
+		return &updatehistory.Filter{Since: now.Add(-time.Duration(c.activity)*24*time.Hour - c.interval)}
+	case c.days > 0:
+		return &updatehistory.Filter{Since: now.AddDate(0, 0, -c.days)}
+	}
+	return nil
+}
+
+func history(ctx context.Context, f *updatehistory.Filter) (*updatehistory.History, error) {
 	// Start Windows update session
 	s, err := session.New()
 	if err != nil {
@@ -68,6 +187,9 @@ func history() (*updatehistory.History, error) {
 	}
 	defer searcher.Close()
 
-	elog.Info(002, "Collecting installed updates...")
-	return updatehistory.Get(searcher)
+	historyLog.Info(002, "Collecting installed updates...")
+	if f != nil {
+		return updatehistory.GetFilteredContext(ctx, searcher, *f)
+	}
+	return updatehistory.GetContext(ctx, searcher)
 }
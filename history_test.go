@@ -0,0 +1,69 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/cabbie/updatehistory"
+	"github.com/google/cabbie/updates"
+)
+
+func TestWithKBs(t *testing.T) {
+	entries := []*updatehistory.Entry{
+		{Title: "Resolved update", UpdateIdentity: updates.Identity{UpdateID: "good"}, KBArticleIDs: []string{"1"}},
+		{Title: "Update (KB4540673)", UpdateIdentity: updates.Identity{UpdateID: "gone"}, KBArticleIDs: []string{"4540673"}},
+	}
+	withKBs(entries, map[string][]string{"good": {"4561608"}})
+
+	if got := entries[0].KBArticleIDs; len(got) != 1 || got[0] != "4561608" {
+		t.Errorf("withKBs() KBs of a resolved entry = %v, want [4561608]", got)
+	}
+	if got := entries[1].KBArticleIDs; len(got) != 1 || got[0] != "4540673" {
+		t.Errorf("withKBs() KBs of an unresolved entry = %v, want those parsed from its title", got)
+	}
+}
+
+func TestHistoryJSON(t *testing.T) {
+	entries := []*updatehistory.Entry{
+		{Title: "Good update", Operation: 1, ResultCode: 2, UpdateIdentity: updates.Identity{UpdateID: "good", RevisionNumber: 200}},
+	}
+
+	b, err := historyJSON(entries, runMeta{"ticket": "CHG12345"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Meta    map[string]string        `json:"meta"`
+		Entries []map[string]interface{} `json:"entries"`
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("historyJSON() returned invalid JSON %s: %v", b, err)
+	}
+	if got.Meta["ticket"] != "CHG12345" {
+		t.Errorf("historyJSON() meta = %v, want ticket=CHG12345", got.Meta)
+	}
+	if len(got.Entries) != 1 || got.Entries[0]["update_id"] != "good" {
+		t.Errorf("historyJSON() entries = %v, want the entry of update good", got.Entries)
+	}
+
+	b, err = historyJSON(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\n  \"entries\": []\n}"; string(b) != want {
+		t.Errorf("historyJSON(nil, nil) = %s, want %s", b, want)
+	}
+}
@@ -18,36 +18,51 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"flag"
 	"github.com/google/cabbie/notification"
 	"github.com/google/cabbie/cablib"
+	"github.com/google/cabbie/compliance"
 	"github.com/google/cabbie/download"
+	"github.com/google/cabbie/errors"
 	"github.com/google/cabbie/install"
 	"github.com/google/cabbie/search"
 	"github.com/google/cabbie/session"
 	"github.com/google/cabbie/updatecollection"
+	"github.com/google/cabbie/updates"
 	"github.com/google/subcommands"
 )
 
 // Available flags
 type installCmd struct {
-	drivers, deadlineOnly, virusDef bool
-	kbs                             string
+	drivers, deadlineOnly, virusDef, force, beta bool
+	kbs, bulletins                               string
+	// plan and approved are paths to the install plan to write for review and the approval decision
+	// restricting which planned updates are installed.
+	plan, approved string
+	// skipDefinitions leaves definition updates out of the run.
+	skipDefinitions bool
+	// summary is the reboot summary of the updates planned by the last installUpdates, or nil when
+	// it planned none.
+	summary *rebootSummary
 }
 
 type installRsp struct {
 	hResult        string
+	hResultCode    errors.UpdateError
 	resultCode     int
 	rebootRequired bool
+	// attempts is the number of times the install was attempted.
+	attempts int
 }
 
 func (installCmd) Name() string     { return "install" }
 func (installCmd) Synopsis() string { return "Install selected available updates." }
 func (installCmd) Usage() string {
-	return fmt.Sprintf("%s install [--drivers | --virusDef | --kbs=\"<KBNumber>\"]\n", filepath.Base(os.Args[0]))
+	return fmt.Sprintf("%s install [--drivers | --virusDef | --kbs=\"<KBNumber>\" | --bulletins=\"<BulletinID>\"] [--force] [--beta] [--plan=\"<File>\" | --approved=\"<File>\"]\n", filepath.Base(os.Args[0]))
 }
 
 func (i *installCmd) SetFlags(f *flag.FlagSet) {
@@ -55,9 +70,14 @@ func (i *installCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&i.virusDef, "virus_def", false, "Update virus definitions.")
 	f.BoolVar(&i.deadlineOnly, "deadlineOnly", false, fmt.Sprintf("Install available updates older than %d days", config.Deadline))
 	f.StringVar(&i.kbs, "kbs", "", "Comma separated string of KB numbers in the form of 1234567.")
+	f.StringVar(&i.bulletins, "bulletins", "", "Comma separated string of security bulletin IDs in the form of MS17-010.")
+	f.BoolVar(&i.force, "force", false, "Download and install updates even during configured active hours.")
+	f.BoolVar(&i.beta, "beta", false, "Include beta updates, which are excluded by default.")
+	f.StringVar(&i.plan, "plan", "", "Write the updates that would be installed to this JSON file for approval instead of installing them.")
+	f.StringVar(&i.approved, "approved", "", "Only install updates approved in this JSON file.")
 }
 
-func (i installCmd) Execute(_ context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (i installCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 	// TODO: Fix logic to allow only 0 to 1 flags at a time.
 	if i.drivers && i.virusDef && i.kbs != "" {
 		fmt.Println("drivers and virus_def flags can not be passed at the same time.")
@@ -65,11 +85,14 @@ func (i installCmd) Execute(_ context.Context, flags *flag.FlagSet, _ ...interfa
 		return subcommands.ExitUsageError
 	}
 
-	if err := i.installUpdates(); err != nil {
+	if err := i.installUpdates(ctx); err != nil {
 		fmt.Printf("Failed to install updates: %v", err)
-		elog.Error(113, fmt.Sprintf("Failed to install updates: %v", err))
+		installLog.Error(113, fmt.Sprintf("Failed to install updates: %v", err))
 		return subcommands.ExitFailure
 	}
+	if i.summary != nil {
+		fmt.Print(i.summary)
+	}
 
 	select {
 	case <-rebootEvent:
@@ -90,60 +113,310 @@ func (i *installCmd) criteria() (string, []string) {
 	case i.drivers:
 		c = "Type='Driver'"
 		rc = append(rc, "Drivers")
-		elog.Info(0021, fmt.Sprintf("Starting search for updated drivers: %s", c))
+		searchLog.Info(0021, fmt.Sprintf("Starting search for updated drivers: %s", c))
 	case i.virusDef:
 		c = fmt.Sprintf("%s AND CategoryIDs contains '%s'", search.BasicSearch, search.DefinitionUpdates)
 		rc = append(rc, "Definition Updates")
-		elog.Info(0022, fmt.Sprintf("Starting search for virus definitions:\n%s", c))
+		searchLog.Info(0022, fmt.Sprintf("Starting search for virus definitions:\n%s", c))
 	case i.kbs != "":
 		c = search.BasicSearch
-		elog.Info(0023, fmt.Sprintf("Starting search for KB's %q:\n%s", i.kbs, c))
+		searchLog.Info(0023, fmt.Sprintf("Starting search for KB's %q:\n%s", i.kbs, c))
+	case i.bulletins != "":
+		c = search.BasicSearch
+		searchLog.Info(0023, fmt.Sprintf("Starting search for security bulletins %q:\n%s", i.bulletins, c))
 	default:
 		c = search.BasicSearch
 		rc = config.RequiredCategories
-		elog.Info(0024, fmt.Sprintf("Starting search for general updates: %s", c))
+		searchLog.Info(0024, fmt.Sprintf("Starting search for general updates: %s", c))
 	}
 	return c, rc
 }
 
+// reportBackoff logs and counts a backoff requested by an update server. Searches skipped because
+// an earlier backoff is still in effect are not counted again.
+func reportBackoff(err error) {
+	b, ok := err.(*errors.BackoffError)
+	if !ok {
+		return
+	}
+	if b.Pending {
+		searchLog.Info(002, fmt.Sprintf("Skipping search, update server backoff in effect until %s.", b.Until.Format(time.RFC3339)))
+		return
+	}
+	searchLog.Warning(208, fmt.Sprintf("Update server requested a backoff of %s: %s", b.RetryAfter, b.Code))
+	if e := searchBackoffCount.Increment(); e != nil {
+		elog.Error(6, fmt.Sprintf("Error posting searchBackoffCount metric:\n%v", e))
+	}
+}
+
+// antivirusOwnsDefinitions reports whether a third-party antivirus product is registered and
+// DeferToAntivirus is enabled. Detection failures, such as on Server editions which lack Security
+// Center, are treated as Defender managing definitions.
+func antivirusOwnsDefinitions() bool {
+	if config.DeferToAntivirus == 0 {
+		return false
+	}
+	av, err := cablib.ThirdPartyAntivirus()
+	if err != nil {
+		installLog.Debug(002, fmt.Sprintf("Unable to detect antivirus products:\n%v", err))
+		return false
+	}
+	if len(av) == 0 {
+		return false
+	}
+	installLog.Debug(002, fmt.Sprintf("Definitions are managed by third-party antivirus: %s", strings.Join(av, ", ")))
+	return true
+}
+
+// prerequisite is a dependency of an update on a servicing stack update. The Windows Update Agent
+// does not expose prerequisites, so they are read from the product categories the updates share, or
+// assumed from titles alone when an update lists no product.
+type prerequisite struct {
+	Update, Requires *updates.Update
+	// Product is the product category both updates apply to, empty when the dependency is assumed.
+	Product string
+}
+
+func (p prerequisite) String() string {
+	if p.Product == "" {
+		return fmt.Sprintf("%s\n  assumed by title to require %s, as no product is listed", p.Update.Title, p.Requires.Title)
+	}
+	return fmt.Sprintf("%s\n  requires %s, as both apply to %s", p.Update.Title, p.Requires.Title, p.Product)
+}
+
+func prereqList(ps []prerequisite) string {
+	var s []string
+	for _, p := range ps {
+		s = append(s, p.String())
+	}
+	return strings.Join(s, "\n")
+}
+
+// sharedProduct returns the first product category of a that b also applies to, and whether both
+// list any product at all.
+func sharedProduct(a, b *updates.Update) (string, bool) {
+	ap, bp := a.Products(), b.Products()
+	if len(ap) == 0 || len(bp) == 0 {
+		return "", false
+	}
+	for _, p := range ap {
+		if cablib.StringInSlice(p, bp) {
+			return p, true
+		}
+	}
+	return "", true
+}
+
+// withPrerequisites orders servicing stack updates ahead of the other updates in planned. Software
+// updates other than definitions and drivers require each servicing stack update, planned or in
+// candidates, that applies to the same product, unless they bundle their own. Candidates superseded
+// by another servicing stack update are left out. Where either update lists no product, the
+// dependency is assumed from the servicing stack title alone. Required candidates that were not
+// planned are added when add is set, and otherwise only reported as prerequisites so
+// missingPrerequisites can warn about them.
+func withPrerequisites(planned, candidates []*updates.Update, add bool) ([]*updates.Update, []prerequisite) {
+	var ssus, others []*updates.Update
+	added := make(map[string]bool)
+	for _, u := range planned {
+		added[u.Identity.UpdateID] = true
+		if u.IsServicingStack() {
+			ssus = append(ssus, u)
+			continue
+		}
+		others = append(others, u)
+	}
+
+	var dependents []*updates.Update
+	for _, u := range others {
+		if !compliance.IsDefinition(u.Categories) && !u.InCategories([]string{"Drivers"}) && !u.BundlesServicingStack() {
+			dependents = append(dependents, u)
+		}
+	}
+	if len(dependents) == 0 {
+		return planned, nil
+	}
+
+	pool := append([]*updates.Update(nil), ssus...)
+	for _, u := range candidates {
+		if u.IsServicingStack() && !u.IsInstalled && !added[u.Identity.UpdateID] {
+			added[u.Identity.UpdateID] = true
+			pool = append(pool, u)
+		}
+	}
+	superseded := make(map[string]bool)
+	for _, u := range pool {
+		for _, id := range u.SupersededUpdateIDs {
+			superseded[id] = true
+		}
+	}
+
+	var prereqs []prerequisite
+	nPlanned := len(ssus)
+	for n, s := range pool {
+		candidate := n >= nPlanned
+		if candidate && superseded[s.Identity.UpdateID] {
+			continue
+		}
+		required := false
+		for _, d := range dependents {
+			if p, listed := sharedProduct(d, s); p != "" || !listed {
+				prereqs = append(prereqs, prerequisite{Update: d, Requires: s, Product: p})
+				required = true
+			}
+		}
+		if candidate && required && add {
+			ssus = append(ssus, s)
+		}
+	}
+	return append(ssus, others...), prereqs
+}
+
+// narrowed reports whether the user asked for specific KBs or bulletins, in which case no other
+// updates should be added to the install.
+func (i *installCmd) narrowed() bool {
+	return i.kbs != "" || i.bulletins != ""
+}
+
+// prerequisiteCandidates returns the uninstalled servicing stack updates in found that pass the same
+// selection as any other update, except for the narrowing to requested KBs or bulletins.
+func (i *installCmd) prerequisiteCandidates(found []*updates.Update) []*updates.Update {
+	var ssus []*updates.Update
+	for _, u := range found {
+		if u.IsServicingStack() && !u.IsInstalled {
+			ssus = append(ssus, u)
+		}
+	}
+	if len(ssus) == 0 {
+		return nil
+	}
+	broad := *i
+	broad.kbs, broad.bulletins = "", ""
+	return broad.selectUpdates(ssus, config.RequiredCategories)
+}
+
+// missingPrerequisites returns the prerequisites whose dependent update is in planned but whose
+// required update is not.
+func missingPrerequisites(planned []*updates.Update, prereqs []prerequisite) []prerequisite {
+	in := make(map[string]bool)
+	for _, u := range planned {
+		in[u.Identity.UpdateID] = true
+	}
+	var m []prerequisite
+	for _, p := range prereqs {
+		if in[p.Update.Identity.UpdateID] && !in[p.Requires.Identity.UpdateID] {
+			m = append(m, p)
+		}
+	}
+	return m
+}
+
+// inActiveHours reports whether t falls within the active hours window beginning at hour start and
+// ending before hour end. Windows that wrap past midnight are supported. Equal start and end hours
+// disable active hours.
+func inActiveHours(t time.Time, start, end uint64) bool {
+	if start == end {
+		return false
+	}
+	h := uint64(t.Hour())
+	if start < end {
+		return h >= start && h < end
+	}
+	return h >= start || h < end
+}
+
+// updateGroup is a set of updates along with their combined maximum download size in bytes.
+type updateGroup struct {
+	Titles []string
+	Size   int64
+}
+
+func (g *updateGroup) add(u *updates.Update) {
+	g.Titles = append(g.Titles, u.Title)
+	g.Size += int64(u.MaxDownloadSize)
+}
+
+// rebootSummary partitions the updates planned for install by whether they may require a reboot.
+type rebootSummary struct {
+	Reboot, NoReboot updateGroup
+}
+
+func summarizeReboots(ups []*updates.Update) rebootSummary {
+	var s rebootSummary
+	for _, u := range ups {
+		if u.RequiresReboot() {
+			s.Reboot.add(u)
+			continue
+		}
+		s.NoReboot.add(u)
+	}
+	return s
+}
+
+func (s rebootSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d updates require reboot, %d don't.\n", len(s.Reboot.Titles), len(s.NoReboot.Titles))
+	for _, g := range []struct {
+		name string
+		updateGroup
+	}{
+		{"Require reboot", s.Reboot},
+		{"No reboot", s.NoReboot},
+	} {
+		if len(g.Titles) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n%s (%d updates, %d bytes to download):\n", g.name, len(g.Titles), g.Size)
+		for _, t := range g.Titles {
+			fmt.Fprintf(&b, "  %s\n", t)
+		}
+	}
+	return b.String()
+}
+
 func installingMessage() {
-	elog.Info(2, "Cabbie is installing new updates.")
+	installLog.Info(2, "Cabbie is installing new updates.")
 
 	if err := notification.NewNotification(cablib.SvcName, notification.NewInstallingMessage(), "installingUpdates"); err != nil {
-		elog.Error(6, fmt.Sprintf("Failed to create notification:\n%v", err))
+		installLog.Error(6, fmt.Sprintf("Failed to create notification:\n%v", err))
 	}
 }
 
 func rebootMessage(seconds int) {
-	elog.Info(2, "Updates have been installed, please reboot to complete the installation...")
+	installLog.Info(2, "Updates have been installed, please reboot to complete the installation...")
 
 	if err := notification.NewNotification(cablib.SvcName, notification.NewRebootMessage(seconds), "rebootPending"); err != nil {
-		elog.Error(6, fmt.Sprintf("Failed to create notification:\n%v", err))
+		installLog.Error(6, fmt.Sprintf("Failed to create notification:\n%v", err))
 	}
 }
 
-func downloadCollection(s *session.UpdateSession, c *updatecollection.Collection) (int, error) {
+func downloadCollection(ctx context.Context, s *session.UpdateSession, c *updatecollection.Collection) (int, error) {
 	d, err := download.NewDownloader(s, c)
 	if err != nil {
 		return 0, fmt.Errorf("error creating downloader:\n %v", err)
 	}
 	defer d.Close()
 
-	if err := d.Download(); err != nil {
+	dctx, cancel := operationContext(ctx, config.DownloadTimeout)
+	defer cancel()
+
+	if err := d.DownloadContext(dctx); err != nil {
 		return 0, fmt.Errorf("error downloading updates:\n %v", err)
 	}
 
 	return d.ResultCode()
 }
 
-func installCollection(s *session.UpdateSession, c *updatecollection.Collection) (*installRsp, error) {
+func installCollection(ctx context.Context, s *session.UpdateSession, c *updatecollection.Collection) (*installRsp, error) {
 	inst, err := install.NewInstaller(s, c)
 	if err != nil {
 		return nil, fmt.Errorf("error creating installer: \n %v", err)
 	}
 	defer inst.Close()
 
-	if err := inst.Install(); err != nil {
+	ictx, cancel := operationContext(ctx, config.InstallTimeout)
+	defer cancel()
+
+	if err := inst.InstallContext(ictx); err != nil {
 		return nil, fmt.Errorf("error installing updates:\n %v", err)
 	}
 
@@ -152,7 +425,7 @@ func installCollection(s *session.UpdateSession, c *updatecollection.Collection)
 		return nil, fmt.Errorf("error getting install ResultCode:\n %v", err)
 	}
 
-	hr, err := inst.HResult()
+	hr, err := inst.HResultCode()
 	if err != nil {
 		return nil, fmt.Errorf("error getting install ReturnCode:\n %v", err)
 	}
@@ -160,13 +433,136 @@ func installCollection(s *session.UpdateSession, c *updatecollection.Collection)
 	rb, err := inst.RebootRequired()
 
 	return &installRsp{
-		hResult:        hr,
+		hResult:        fmt.Sprintf("%s", hr),
+		hResultCode:    hr,
 		resultCode:     rc,
 		rebootRequired: rb,
+		attempts:       1,
 	}, err
 }
 
-func (i *installCmd) installUpdates() error {
+// installWithRetry installs the collection, retrying failures with a retryable HResult up to
+// InstallRetries times, waiting InstallRetryDelay seconds between attempts.
+func installWithRetry(ctx context.Context, s *session.UpdateSession, c *updatecollection.Collection, title string) (*installRsp, error) {
+	delay := time.Duration(config.InstallRetryDelay) * time.Second
+	for attempt := 1; ; attempt++ {
+		rsp, err := installCollection(ctx, s, c)
+		if rsp != nil {
+			rsp.attempts = attempt
+		}
+		if err != nil || rsp.resultCode == 2 {
+			return rsp, err
+		}
+		if attempt > int(config.InstallRetries) || !retryableInstall(rsp.hResultCode, config.InstallRetryCodes) {
+			return rsp, nil
+		}
+
+		installLog.Info(002, fmt.Sprintf("Install of update %s failed with retryable HResult %s, retrying in %s (attempt %d of %d).",
+			title, rsp.hResult, delay, attempt+1, config.InstallRetries+1))
+		select {
+		case <-ctx.Done():
+			return rsp, nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryableInstall reports whether an install failing with hr may succeed when retried. retryable
+// holds hexadecimal HResults such as "0x80070020".
+func retryableInstall(hr errors.UpdateError, retryable []string) bool {
+	for _, r := range retryable {
+		v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(r)), "0x"), 16, 32)
+		if err != nil {
+			installLog.Error(206, fmt.Sprintf("Ignoring invalid retryable install HResult %q: %v", r, err))
+			continue
+		}
+		if errors.UpdateError(v) == hr {
+			return true
+		}
+	}
+	return false
+}
+
+// selectUpdates returns the updates that match the requested categories, KBs and deadline.
+func (i *installCmd) selectUpdates(ups []*updates.Update, rc []string) []*updates.Update {
+	var selected []*updates.Update
+	var betas, definitions []string
+	kbs := NewKBSet(i.kbs)
+	for _, u := range ups {
+		if i.skipDefinitions && compliance.IsDefinition(u.Categories) {
+			definitions = append(definitions, u.Title)
+			continue
+		}
+		if !(u.InCategories(rc)) {
+			installLog.Debug(1, fmt.Sprintf("Skipping update %s.\nRequiredClassifications:\n%v\nUpdate classifications:\n%v",
+				u.Title,
+				rc,
+				u.Categories))
+			continue
+		}
+
+		if kbs.Size() > 0 {
+			if !kbs.Search(u.KBArticleIDs) {
+				installLog.Debug(1, fmt.Sprintf("Skipping update %s.\nRequired KBs:\n%s\nUpdate KBs:\n%v",
+					u.Title,
+					kbs,
+					u.KBArticleIDs))
+				continue
+			}
+		}
+		if i.bulletins != "" {
+			if !u.InBulletins(strings.Split(i.bulletins, ",")) {
+				installLog.Debug(1, fmt.Sprintf("Skipping update %s.\nRequired security bulletins:\n%s\nUpdate security bulletins:\n%v",
+					u.Title,
+					i.bulletins,
+					u.SecurityBulletinIDs))
+				continue
+			}
+		}
+		if i.deadlineOnly {
+			deadline := time.Duration(config.Deadline) * 24 * time.Hour
+			pastDeadline := time.Now().After(u.LastDeploymentChangeTime.Add(deadline))
+			if !pastDeadline {
+				installLog.Debug(002,
+					fmt.Sprintf("Skipping update %s.\nUpdate deployed on %v has not reached the %d day threshold.",
+						u.Title,
+						u.LastDeploymentChangeTime,
+						config.Deadline))
+				continue
+			}
+		}
+		if u.IsBeta && !(i.beta || config.InstallBeta == 1) {
+			betas = append(betas, u.Title)
+			continue
+		}
+		selected = append(selected, u)
+	}
+	if len(definitions) > 0 {
+		installLog.Info(002, fmt.Sprintf("Skipping %d definition updates, which are installed separately:\n%s",
+			len(definitions), strings.Join(definitions, "\n")))
+	}
+	if len(betas) > 0 {
+		installLog.Info(002, fmt.Sprintf("Skipping %d beta updates, use --beta or set InstallBeta to install them:\n%s",
+			len(betas), strings.Join(betas, "\n")))
+	}
+	return selected
+}
+
+// installUpdates searches for, downloads and installs the selected updates, recording the reboot
+// summary of those planned in i.summary. Canceling ctx aborts the operation in progress and skips any
+// remaining updates.
+func (i *installCmd) installUpdates(ctx context.Context) error {
+	i.summary = nil
+	if i.virusDef && antivirusOwnsDefinitions() {
+		installLog.Info(002, "Skipping virus definitions, a third-party antivirus product manages them.")
+		return nil
+	}
+	// Definitions are installed on their own schedule by the main loop, or not at all when a
+	// third-party antivirus product manages them. Explicitly requested KBs are always honored.
+	if !i.virusDef && i.kbs == "" && i.bulletins == "" {
+		i.skipDefinitions = config.UpdateVirusDef == 1 || antivirusOwnsDefinitions()
+	}
+
 	var rebootRequired bool
 	// Check for reboot status when not installing virus definitions.
 	if !(i.virusDef) {
@@ -196,65 +592,120 @@ func (i *installCmd) installUpdates() error {
 	}
 	defer q.Close()
 
-	uc, err := q.QueryUpdates()
+	sctx, cancel := operationContext(ctx, config.SearchTimeout)
+	defer cancel()
+
+	uc, err := q.QueryUpdatesContext(sctx)
 	if er := searchHResult.Set(q.SearchHResult); er != nil {
 		elog.Error(206, fmt.Sprintf("Error posting metric:\n%v", er))
 	}
+	reportBackoff(err)
 	if err != nil {
 		return fmt.Errorf("error encountered when attempting to query for updates: %v", err)
 	}
 	defer uc.Close()
 
 	if len(uc.Updates) == 0 {
-		elog.Info(002, "No updates found to install.")
+		searchLog.Info(002, "No updates found to install.")
+		return nil
+	}
+	searchLog.Info(4, fmt.Sprintf("Updates Found:\n%s", strings.Join(uc.Titles(), "\n\n")))
+
+	// Virus definitions are small and time sensitive so they are never deferred. Writing a plan
+	// downloads and installs nothing, so it runs during active hours too.
+	if !i.force && !i.virusDef && i.plan == "" && inActiveHours(time.Now(), config.ActiveHoursStart, config.ActiveHoursEnd) {
+		installLog.Info(002, fmt.Sprintf("Deferring download and install of %d updates during active hours (%02d:00-%02d:00). Use --force to override.",
+			len(uc.Updates), config.ActiveHoursStart, config.ActiveHoursEnd))
+		return nil
+	}
+
+	st, err := loadState(stateFile)
+	if err != nil {
+		installLog.Error(207, fmt.Sprintf("Failed to load previous state, starting fresh:\n%v", err))
+	}
+	defer func() {
+		if err := st.save(stateFile); err != nil {
+			installLog.Error(207, fmt.Sprintf("Failed to save state:\n%v", err))
+		}
+	}()
+
+	planned, prereqs := withPrerequisites(i.selectUpdates(uc.Updates, rc), i.prerequisiteCandidates(uc.Updates), !i.narrowed())
+	if len(prereqs) > 0 {
+		installLog.Info(002, fmt.Sprintf("Installing servicing stack updates first:\n%s", prereqList(prereqs)))
+	}
+	if m := missingPrerequisites(planned, prereqs); len(m) > 0 {
+		installLog.Warning(002, fmt.Sprintf("Selected updates may fail to install without servicing stack updates left out by --kbs or --bulletins:\n%s", prereqList(m)))
+	}
+	summary := summarizeReboots(planned)
+	if i.plan != "" {
+		if err := writeInstallPlan(i.plan, planned); err != nil {
+			return err
+		}
+		i.summary = &summary
+		installLog.Info(002, fmt.Sprintf("Wrote install plan of %d updates to %s:\n%s", len(planned), i.plan, summary))
 		return nil
 	}
-	elog.Info(4, fmt.Sprintf("Updates Found:\n%s", strings.Join(uc.Titles(), "\n\n")))
+	if i.approved != "" {
+		d, err := loadApproval(i.approved)
+		if err != nil {
+			return err
+		}
+		var rejected []string
+		planned, rejected = d.filter(planned)
+		summary = summarizeReboots(planned)
+		if len(rejected) > 0 {
+			installLog.Info(002, fmt.Sprintf("Skipping %d updates not approved in %s:\n%s",
+				len(rejected), i.approved, strings.Join(rejected, "\n")))
+		}
+		if m := missingPrerequisites(planned, prereqs); len(m) > 0 {
+			installLog.Warning(002, fmt.Sprintf("Approved updates may fail to install without unapproved servicing stack updates:\n%s", prereqList(m)))
+		}
+	}
+	if len(planned) == 0 {
+		installLog.Info(002, "No updates selected to install.")
+		return nil
+	}
+	i.summary = &summary
+	installLog.Info(002, fmt.Sprintf("Planned install:\n%s", summary))
+
+	budget := newDownloadBudget(st, config.DownloadBudget, config.MonthlyBudget == 1, time.Now())
+	var deferred []string
 
 	installMsgPopped := i.virusDef
 
-	kbs := NewKBSet(i.kbs)
-	for _, u := range uc.Updates {
-		if !(u.InCategories(rc)) {
-			elog.Info(1, fmt.Sprintf("Skipping update %s.\nRequiredClassifications:\n%v\nUpdate classifications:\n%v",
-				u.Title,
-				rc,
-				u.Categories))
-			continue
+	for n, u := range planned {
+		if ctx.Err() != nil {
+			installLog.Info(002, fmt.Sprintf("Shutdown requested, skipping remaining updates starting with:\n%s", u.Title))
+			break
 		}
 
-		if !(u.EulaAccepted) {
-			elog.Info(002, fmt.Sprintf("Accepting EULA for update: %s", u.Title))
-			if err := u.AcceptEula(); err != nil {
-				elog.Error(202, fmt.Sprintf("Failed to accept EULA for update %s:\n%s", u.Title, err))
+		// Updates already in the local cache don't count against the download budget.
+		size := int64(u.MaxDownloadSize)
+		if u.IsDownloaded {
+			size = 0
+		}
+		// Once the budget is reached nothing further is queued, even updates small enough to fit.
+		if !budget.allows(size) {
+			for _, r := range planned[n:] {
+				deferred = append(deferred, r.Title)
 			}
+			break
 		}
 
-		if kbs.Size() > 0 {
-			if !kbs.Search(u.KBArticleIDs) {
-				elog.Info(1, fmt.Sprintf("Skipping update %s.\nRequired KBs:\n%s\nUpdate KBs:\n%v",
-					u.Title,
-					kbs,
-					u.KBArticleIDs))
+		if !(u.EulaAccepted) {
+			if config.AutoAcceptEula == 0 {
+				installLog.Info(002, fmt.Sprintf("Skipping update %s, its EULA has not been accepted and AutoAcceptEula is disabled.", u.Title))
 				continue
 			}
-		}
-		if i.deadlineOnly {
-			deadline := time.Duration(config.Deadline) * 24 * time.Hour
-			pastDeadline := time.Now().After(u.LastDeploymentChangeTime.Add(deadline))
-			if !pastDeadline {
-				elog.Info(002,
-					fmt.Sprintf("Skipping update %s.\nUpdate deployed on %v has not reached the %d day threshold.",
-						u.Title,
-						u.LastDeploymentChangeTime,
-						config.Deadline))
-				continue
+			installLog.Info(002, fmt.Sprintf("Accepting EULA for update: %s", u.Title))
+			if err := u.AcceptEula(); err != nil {
+				installLog.Error(202, fmt.Sprintf("Failed to accept EULA for update %s:\n%s", u.Title, err))
 			}
 		}
 
 		c, err := updatecollection.New()
 		if err != nil {
-			elog.Error(202, fmt.Sprintf("Failed to create collection: %v", err))
+			installLog.Error(202, fmt.Sprintf("Failed to create collection: %v", err))
 			continue
 		}
 		c.Add(u.Item)
@@ -263,28 +714,31 @@ func (i *installCmd) installUpdates() error {
 			installingMessage()
 			installMsgPopped = true
 		}
-		elog.Info(002, fmt.Sprintf("Downloading Update:\n%v", u))
+		downloadLog.Info(002, fmt.Sprintf("Downloading Update:\n%v", u))
 
-		rc, err := downloadCollection(s, c)
+		rc, err := downloadCollection(ctx, s, c)
+		st.recordDownload(u, rc, err)
 		if err != nil {
-			elog.Error(203, fmt.Sprintf("%v", err))
+			downloadLog.Error(203, fmt.Sprintf("%v", err))
 			c.Close()
 			continue
 		}
 		if rc == 2 {
-			elog.Info(002, fmt.Sprintf("Successfully downloaded update:\n %s", u.Title))
+			budget.consume(size)
+			downloadLog.Info(002, fmt.Sprintf("Successfully downloaded update:\n %s", u.Title))
 		} else {
 
-			elog.Error(204, fmt.Sprintf("Failed to download update:\n %s\n ReturnCode: %d", u.Title, rc))
+			downloadLog.Error(204, fmt.Sprintf("Failed to download update:\n %s\n ReturnCode: %d", u.Title, rc))
 			c.Close()
 			continue
 		}
 
-		elog.Info(002, fmt.Sprintf("Installing Update:\n%v", u))
+		installLog.Info(002, fmt.Sprintf("Installing Update:\n%v", u))
 
-		rsp, err := installCollection(s, c)
+		rsp, err := installWithRetry(ctx, s, c, u.Title)
+		st.recordInstall(u, rsp, err)
 		if err != nil {
-			elog.Error(205, fmt.Sprintf("%v", err))
+			installLog.Error(205, fmt.Sprintf("%v", err))
 			c.Close()
 			continue
 		}
@@ -293,24 +747,29 @@ func (i *installCmd) installUpdates() error {
 			elog.Error(206, fmt.Sprintf("Error posting metric:\n%v", err))
 		}
 		if rsp.resultCode == 2 {
-			elog.Info(002, fmt.Sprintf("Successfully installed update:\n%s\nHResult Code: %s", u.Title, rsp.hResult))
+			installLog.Info(002, fmt.Sprintf("Successfully installed update:\n%s\nHResult Code: %s\nAttempts: %d", u.Title, rsp.hResult, rsp.attempts))
 		} else {
-			elog.Error(206, fmt.Sprintf("Failed to install update:\n%s\nReturnCode: %d\nHResult Code: %s", u.Title, rsp.resultCode, rsp.hResult))
+			installLog.Error(206, fmt.Sprintf("Failed to install update:\n%s\nReturnCode: %d\nHResult Code: %s\nAttempts: %d", u.Title, rsp.resultCode, rsp.hResult, rsp.attempts))
 			c.Close()
 			continue
 		}
 
-		elog.Info(002, fmt.Sprintf("Install Reboot Required: %t", rsp.rebootRequired))
+		installLog.Info(002, fmt.Sprintf("Install Reboot Required: %t", rsp.rebootRequired))
 		if !rebootRequired {
 			rebootRequired = rsp.rebootRequired
 		}
 		c.Close()
 	}
 
+	if len(deferred) > 0 {
+		downloadLog.Info(002, fmt.Sprintf("Download budget reached (%s), deferring %d updates to a later run:\n%s",
+			budget, len(deferred), strings.Join(deferred, "\n")))
+	}
+
 	if rebootRequired {
 		rebootMessage(int(config.RebootDelay))
 		if err := cablib.SetRebootTime(config.RebootDelay); err != nil {
-			elog.Error(306, fmt.Sprintf("Failed to run reboot command:\n%v", err))
+			installLog.Error(306, fmt.Sprintf("Failed to run reboot command:\n%v", err))
 		}
 		rebootEvent <- rebootRequired
 	}
@@ -16,8 +16,11 @@
 package install
 
 import (
+	"context"
 	"fmt"
 
+	"github.com/google/cabbie/cablib"
+	"github.com/google/cabbie/logging"
 	"github.com/google/cabbie/errors"
 	"github.com/google/cabbie/session"
 	"github.com/google/cabbie/updatecollection"
@@ -25,6 +28,8 @@ import (
 	"github.com/go-ole/go-ole/oleutil"
 )
 
+var log = logging.For("install")
+
 // Installer represents an update Install interface.
 // https://docs.microsoft.com/en-us/windows/desktop/api/wuapi/nn-wuapi-iupdateinstaller
 type Installer struct {
@@ -48,16 +53,41 @@ func NewInstaller(us *session.UpdateSession, uc *updatecollection.Collection) (*
 
 // Install will install the requested updates.
 func (i *Installer) Install() error {
-	r, err := oleutil.CallMethod(i.IUpdateInstaller, "Install")
-	i.IInstallationResult = r.ToIDispatch()
+	return i.InstallContext(context.Background())
+}
+
+// InstallContext installs the requested updates, aborting the installation if the context is
+// cancelled or its deadline passes before it completes.
+func (i *Installer) InstallContext(ctx context.Context) error {
+	if err := cablib.CheckWritable("install"); err != nil {
+		return err
+	}
+	j, err := oleutil.CallMethod(i.IUpdateInstaller, "BeginInstall", nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("install error: %v", err)
+	}
+	job := j.ToIDispatch()
+	defer job.Release()
+	defer oleutil.CallMethod(job, "CleanUp")
+
+	log.Debug(2, "Started install job")
+	if err := cablib.WaitForJob(ctx, job, "install"); err != nil {
+		return fmt.Errorf("install error: %v", err)
+	}
+
+	r, err := oleutil.CallMethod(i.IUpdateInstaller, "EndInstall", job)
 	if err != nil {
-		return fmt.Errorf("install error: [%s] [%v]", errors.UpdateError(r.Val), err)
+		return fmt.Errorf("install error: %v", err)
 	}
+	i.IInstallationResult = r.ToIDispatch()
 	return nil
 }
 
 // Uninstall starts a synchronous uninstallation of the updates.
 func (i *Installer) Uninstall() error {
+	if err := cablib.CheckWritable("uninstall"); err != nil {
+		return err
+	}
 	r, err := oleutil.CallMethod(i.IUpdateInstaller, "Uninstall")
 	i.IInstallationResult = r.ToIDispatch()
 	if err != nil {
@@ -77,11 +107,20 @@ func (i *Installer) IsBusy() (bool, error) {
 
 // HResult gets the HRESULT of the exception, if any, that is raised during the installation.
 func (i *Installer) HResult() (string, error) {
+	hr, err := i.HResultCode()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s", hr), nil
+}
+
+// HResultCode gets the HResult of the installation as an UpdateError.
+func (i *Installer) HResultCode() (errors.UpdateError, error) {
 	hr, err := oleutil.GetProperty(i.IInstallationResult, "HResult")
 	if err != nil {
-		return "", fmt.Errorf("error getting HResult property: %v", err)
+		return 0, fmt.Errorf("error getting HResult property: %v", err)
 	}
-	return fmt.Sprintf("%s", errors.UpdateError(hr.Val)), nil
+	return errors.UpdateError(hr.Val), nil
 }
 
 // ResultCode gets an OperationResultCode value that specifies the result of an operation on an update.
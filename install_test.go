@@ -16,8 +16,11 @@ package main
 import (
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/cabbie/errors"
 	"github.com/google/cabbie/search"
+	"github.com/google/cabbie/updates"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -88,3 +91,207 @@ func TestGetCriteria(t *testing.T) {
 		}
 	}
 }
+
+func TestInActiveHours(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2020, 6, 1, hour, 30, 0, 0, time.Local) }
+	for _, tt := range []struct {
+		t          time.Time
+		start, end uint64
+		out        bool
+	}{
+		{at(10), 0, 0, false},
+		{at(10), 8, 17, true},
+		{at(8), 8, 17, true},
+		{at(17), 8, 17, false},
+		{at(7), 8, 17, false},
+		{at(23), 22, 6, true},
+		{at(3), 22, 6, true},
+		{at(12), 22, 6, false},
+	} {
+		o := inActiveHours(tt.t, tt.start, tt.end)
+		if o != tt.out {
+			t.Errorf("inActiveHours(%v, %d, %d) = %t, want %t", tt.t, tt.start, tt.end, o, tt.out)
+		}
+	}
+}
+
+func TestSummarizeReboots(t *testing.T) {
+	ups := []*updates.Update{
+		{Title: "Cumulative Update", MaxDownloadSize: 500, InstallationBehavior: updates.InstallationBehavior{RebootBehavior: updates.AlwaysRequiresReboot}},
+		{Title: "Definition Update", MaxDownloadSize: 10},
+		{Title: "Servicing Stack Update", MaxDownloadSize: 50, InstallationBehavior: updates.InstallationBehavior{RebootBehavior: updates.CanRequestReboot}},
+		{Title: "Pending Update", MaxDownloadSize: 5, RebootRequired: true},
+	}
+	want := rebootSummary{
+		Reboot:   updateGroup{Titles: []string{"Cumulative Update", "Servicing Stack Update", "Pending Update"}, Size: 555},
+		NoReboot: updateGroup{Titles: []string{"Definition Update"}, Size: 10},
+	}
+	got := summarizeReboots(ups)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("summarizeReboots() returned diff (-want +got):\n%s", diff)
+	}
+	if s := got.String(); !strings.HasPrefix(s, "3 updates require reboot, 1 don't.") {
+		t.Errorf("String() got: %q, want prefix: %q", s, "3 updates require reboot, 1 don't.")
+	}
+}
+
+func TestSelectUpdatesBeta(t *testing.T) {
+	elog = new(testInstallLog)
+	config = newFakeConfig()
+	ups := []*updates.Update{
+		{Title: "Release"},
+		{Title: "Beta", IsBeta: true},
+	}
+	for _, tt := range []struct {
+		i    installCmd
+		want []string
+	}{
+		{installCmd{}, []string{"Release"}},
+		{installCmd{beta: true}, []string{"Release", "Beta"}},
+	} {
+		var got []string
+		for _, u := range tt.i.selectUpdates(ups, nil) {
+			got = append(got, u.Title)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("selectUpdates(beta=%t) returned diff (-want +got):\n%s", tt.i.beta, diff)
+		}
+	}
+}
+
+func TestSelectUpdatesDefinitions(t *testing.T) {
+	elog = new(testInstallLog)
+	config = newFakeConfig()
+	ups := []*updates.Update{
+		{Title: "Security"},
+		{Title: "Definition", Categories: []updates.Category{{Name: "Definition Updates", CategoryID: string(search.DefinitionUpdates)}}},
+	}
+	for _, tt := range []struct {
+		i    installCmd
+		want []string
+	}{
+		{installCmd{}, []string{"Security", "Definition"}},
+		{installCmd{skipDefinitions: true}, []string{"Security"}},
+	} {
+		var got []string
+		for _, u := range tt.i.selectUpdates(ups, nil) {
+			got = append(got, u.Title)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("selectUpdates(skipDefinitions=%t) returned diff (-want +got):\n%s", tt.i.skipDefinitions, diff)
+		}
+	}
+}
+
+func TestWithPrerequisites(t *testing.T) {
+	win10 := updates.Category{Name: "Windows 10", Type: "Product"}
+	server := updates.Category{Name: "Windows Server 2019", Type: "Product"}
+	ssu := &updates.Update{Title: "Servicing Stack Update (KB1)", Identity: updates.Identity{UpdateID: "ssu"}}
+	cu := &updates.Update{Title: "Cumulative Update (KB2)", Identity: updates.Identity{UpdateID: "cu"}}
+	def := &updates.Update{Title: "Definition", Identity: updates.Identity{UpdateID: "def"}, Categories: []updates.Category{{Name: "Definition Updates", CategoryID: string(search.DefinitionUpdates)}}}
+	installedSSU := &updates.Update{Title: "Old Servicing Stack Update", Identity: updates.Identity{UpdateID: "old"}, IsInstalled: true}
+	found := []*updates.Update{cu, def, ssu, installedSSU}
+
+	winSSU := &updates.Update{Title: "Servicing Stack Update for Windows 10 (KB3)", Identity: updates.Identity{UpdateID: "win-ssu"}, Categories: []updates.Category{win10}}
+	newSSU := &updates.Update{Title: "Servicing Stack Update for Windows 10 (KB4)", Identity: updates.Identity{UpdateID: "new-ssu"}, Categories: []updates.Category{win10}, SupersededUpdateIDs: []string{"win-ssu"}}
+	serverSSU := &updates.Update{Title: "Servicing Stack Update for Windows Server 2019 (KB5)", Identity: updates.Identity{UpdateID: "server-ssu"}, Categories: []updates.Category{server}}
+	winCU := &updates.Update{Title: "Cumulative Update for Windows 10 (KB6)", Identity: updates.Identity{UpdateID: "win-cu"}, Categories: []updates.Category{win10}}
+	combined := &updates.Update{Title: "Cumulative Update for Windows 10 (KB7)", Identity: updates.Identity{UpdateID: "combined"}, Categories: []updates.Category{win10},
+		BundledUpdates: []updates.BundledUpdate{{Title: "Servicing Stack Update for Windows 10 (KB8)"}}}
+	products := []*updates.Update{winSSU, newSSU, serverSSU, winCU, combined}
+
+	for _, tt := range []struct {
+		desc       string
+		planned    []*updates.Update
+		candidates []*updates.Update
+		want       []string
+		products   []string
+	}{
+		{"adds missing SSU first", []*updates.Update{cu, def}, found, []string{"ssu", "cu", "def"}, []string{""}},
+		{"reorders planned SSU", []*updates.Update{cu, ssu}, found, []string{"ssu", "cu"}, []string{""}},
+		{"definitions only", []*updates.Update{def}, found, []string{"def"}, nil},
+		{"newest SSU for the same product", []*updates.Update{winCU}, products, []string{"new-ssu", "win-cu"}, []string{"Windows 10"}},
+		{"bundled SSU", []*updates.Update{combined}, products, []string{"combined"}, nil},
+	} {
+		got, prereqs := withPrerequisites(tt.planned, tt.candidates, true)
+		var ids []string
+		for _, u := range got {
+			ids = append(ids, u.Identity.UpdateID)
+		}
+		if diff := cmp.Diff(tt.want, ids); diff != "" {
+			t.Errorf("withPrerequisites(%s) returned diff (-want +got):\n%s", tt.desc, diff)
+		}
+		var ps []string
+		for _, p := range prereqs {
+			ps = append(ps, p.Product)
+		}
+		if diff := cmp.Diff(tt.products, ps); diff != "" {
+			t.Errorf("withPrerequisites(%s) prerequisite products returned diff (-want +got):\n%s", tt.desc, diff)
+		}
+	}
+
+	// A selection narrowed to specific KBs only reports the servicing stack update it leaves out.
+	got, prereqs := withPrerequisites([]*updates.Update{cu}, found, false)
+	if len(got) != 1 || got[0] != cu {
+		t.Errorf("withPrerequisites(not adding) = %v, want only the cumulative update", got)
+	}
+	if m := missingPrerequisites(got, prereqs); len(m) != 1 || m[0].Requires != ssu {
+		t.Errorf("missingPrerequisites() = %v, want the servicing stack update", m)
+	}
+}
+
+func TestPrerequisiteString(t *testing.T) {
+	ssu := &updates.Update{Title: "Servicing Stack Update (KB1)"}
+	cu := &updates.Update{Title: "Cumulative Update (KB2)"}
+	for _, tt := range []struct {
+		p    prerequisite
+		want string
+	}{
+		{prerequisite{cu, ssu, "Windows 10"}, "Cumulative Update (KB2)\n  requires Servicing Stack Update (KB1), as both apply to Windows 10"},
+		{prerequisite{cu, ssu, ""}, "Cumulative Update (KB2)\n  assumed by title to require Servicing Stack Update (KB1), as no product is listed"},
+	} {
+		if got := tt.p.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestPrerequisiteCandidates(t *testing.T) {
+	elog = new(testInstallLog)
+	config = newFakeConfig()
+	security := []updates.Category{{Name: "Security Updates"}}
+	ssu := &updates.Update{Title: "Servicing Stack Update (KB1)", KBArticleIDs: []string{"1"}, Categories: security}
+	found := []*updates.Update{
+		ssu,
+		{Title: "Cumulative Update (KB2)", KBArticleIDs: []string{"2"}, Categories: security},
+		{Title: "Beta Servicing Stack Update (KB3)", IsBeta: true, Categories: security},
+		{Title: "Installed Servicing Stack Update (KB4)", IsInstalled: true, Categories: security},
+		{Title: "Servicing Stack Update (KB5)", Categories: []updates.Category{{Name: "Updates"}}},
+	}
+	// Candidates ignore the requested KBs but not the beta exclusion or RequiredCategories.
+	i := &installCmd{kbs: "2"}
+	if diff := cmp.Diff([]*updates.Update{ssu}, i.prerequisiteCandidates(found)); diff != "" {
+		t.Errorf("prerequisiteCandidates() returned diff (-want +got):\n%s", diff)
+	}
+	if !i.narrowed() {
+		t.Errorf("narrowed() of --kbs install = false, want true")
+	}
+}
+
+func TestRetryableInstall(t *testing.T) {
+	elog = new(testInstallLog)
+	retryable := []string{"0x80070020", " 0X80240016", "bogus"}
+	for _, tt := range []struct {
+		in  errors.UpdateError
+		out bool
+	}{
+		{0x80070020, true},
+		{errors.WU_E_INSTALL_NOT_ALLOWED, true},
+		{errors.WU_E_SERVICE_STOP, false},
+		{errors.SUCCESS, false},
+	} {
+		if got := retryableInstall(tt.in, retryable); got != tt.out {
+			t.Errorf("retryableInstall(%#x) = %t, want %t", int64(tt.in), got, tt.out)
+		}
+	}
+}
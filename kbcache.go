@@ -0,0 +1,216 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/cabbie/session"
+	"github.com/google/cabbie/updates"
+)
+
+// defaultKBCache is where the UpdateID to KB mapping is cached unless KBCachePath is set.
+var defaultKBCache = filepath.Join(stateDir, "kbcache.json")
+
+const (
+	// kbSearchBatch is the most identities matched by a single search, keeping the criteria of
+	// large histories within what the Windows Update Agent accepts.
+	kbSearchBatch = 50
+	// kbMissTTL is how long a revision the search did not return is cached as missing before it
+	// is searched for again.
+	kbMissTTL = 7 * 24 * time.Hour
+)
+
+// kbCache maps update revisions to the KB article IDs reported by search, so repeated runs don't
+// query for updates they have already resolved. Revisions the search did not return are cached as
+// misses for kbMissTTL.
+type kbCache struct {
+	Revisions map[string]*kbCacheEntry `json:"revisions"`
+}
+
+// kbCacheEntry is the KB article IDs of a single revision of an update, or a record that the search
+// did not return the revision when last checked.
+type kbCacheEntry struct {
+	KBArticleIDs []string  `json:"kb_article_ids,omitempty"`
+	NotFound     bool      `json:"not_found,omitempty"`
+	Checked      time.Time `json:"checked"`
+}
+
+// kbCacheKey is the key of id in the cache. UpdateIDs are GUIDs and compared regardless of case.
+func kbCacheKey(id updates.Identity) string {
+	return strings.ToLower(id.String())
+}
+
+// loadKBCache reads the cache at path. A missing file returns an empty cache.
+func loadKBCache(path string) (*kbCache, error) {
+	c := &kbCache{Revisions: make(map[string]*kbCacheEntry)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("loadKBCache: error reading %q: %v", path, err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return c, fmt.Errorf("loadKBCache: error unmarshalling %q: %v", path, err)
+	}
+	if c.Revisions == nil {
+		c.Revisions = make(map[string]*kbCacheEntry)
+	}
+	return c, nil
+}
+
+func (c *kbCache) save(path string) error {
+	if err := writeJSONFile(path, c); err != nil {
+		return fmt.Errorf("saveKBCache: %v", err)
+	}
+	return nil
+}
+
+// lookup returns the cached entry of id as of now. Misses checked more than kbMissTTL ago are
+// expired and not returned.
+func (c *kbCache) lookup(id updates.Identity, now time.Time) (*kbCacheEntry, bool) {
+	e, ok := c.Revisions[kbCacheKey(id)]
+	if !ok || (e.NotFound && now.Sub(e.Checked) > kbMissTTL) {
+		return nil, false
+	}
+	return e, true
+}
+
+// add caches the KBs of revision id, checked at now.
+func (c *kbCache) add(id updates.Identity, kbs []string, now time.Time) {
+	c.Revisions[kbCacheKey(id)] = &kbCacheEntry{KBArticleIDs: kbs, Checked: now}
+}
+
+// addMiss caches that the search did not return revision id when checked at now.
+func (c *kbCache) addMiss(id updates.Identity, now time.Time) {
+	c.Revisions[kbCacheKey(id)] = &kbCacheEntry{NotFound: true, Checked: now}
+}
+
+// missing returns the identities in ids without a current cache entry as of now, without
+// duplicates.
+func (c *kbCache) missing(ids []updates.Identity, now time.Time) []updates.Identity {
+	var m []updates.Identity
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		k := kbCacheKey(id)
+		if _, ok := c.lookup(id, now); ok || seen[k] || id.UpdateID == "" {
+			continue
+		}
+		seen[k] = true
+		m = append(m, id)
+	}
+	return m
+}
+
+// resolveKBs returns the KB article IDs of each update in ids keyed by UpdateID. Revisions missing
+// from the cache at config.KBCachePath are searched for and cached, including those the search no
+// longer returns, which are left out of the result.
+func resolveKBs(ctx context.Context, ids []updates.Identity) (map[string][]string, error) {
+	c, err := loadKBCache(config.KBCachePath)
+	if err != nil {
+		searchLog.Error(209, fmt.Sprintf("Failed to load KB cache, starting fresh:\n%v", err))
+	}
+
+	now := time.Now()
+	if m := c.missing(ids, now); len(m) > 0 {
+		searchLog.Debug(002, fmt.Sprintf("Resolving KBs of %d updates not in the KB cache", len(m)))
+		found, serr := searchKBs(ctx, m)
+		for _, id := range m {
+			if kbs, ok := found[kbCacheKey(id)]; ok {
+				c.add(id, kbs, now)
+			} else if serr == nil {
+				// Only a completed search shows the revision is gone.
+				c.addMiss(id, now)
+			}
+		}
+		if err := c.save(config.KBCachePath); err != nil {
+			searchLog.Error(209, fmt.Sprintf("Failed to save KB cache:\n%v", err))
+		}
+		if serr != nil {
+			return nil, serr
+		}
+	}
+
+	kbs := make(map[string][]string)
+	for _, id := range ids {
+		if e, ok := c.lookup(id, now); ok && !e.NotFound {
+			kbs[id.UpdateID] = e.KBArticleIDs
+		}
+	}
+	return kbs, nil
+}
+
+// identityCriteria returns the search criteria matching the revisions in ids, kbSearchBatch
+// revisions per criteria.
+func identityCriteria(ids []updates.Identity) []string {
+	var crits []string
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > kbSearchBatch {
+			n = kbSearchBatch
+		}
+		crit := make([]string, n)
+		for i, id := range ids[:n] {
+			crit[i] = fmt.Sprintf("(UpdateID='%s' and RevisionNumber=%d)", id.UpdateID, id.RevisionNumber)
+		}
+		crits = append(crits, strings.Join(crit, " or "))
+		ids = ids[n:]
+	}
+	return crits
+}
+
+// searchKBs searches for the revisions in ids, installed or not, and returns the KB article IDs of
+// those found keyed by kbCacheKey. On error, the KBs found by the batches searched so far are
+// returned with it.
+func searchKBs(ctx context.Context, ids []updates.Identity) (map[string][]string, error) {
+	found := make(map[string][]string)
+
+	// Start Windows update session
+	s, err := session.New()
+	if err != nil {
+		return found, fmt.Errorf("failed to create new Windows Update session: %v", err)
+	}
+	defer s.Close()
+
+	for _, crit := range identityCriteria(ids) {
+		if err := searchKBBatch(ctx, s, crit, found); err != nil {
+			return found, err
+		}
+	}
+	return found, nil
+}
+
+// searchKBBatch adds the KB article IDs of the updates matching crit, searched in the session s, to
+// found.
+func searchKBBatch(ctx context.Context, s *session.UpdateSession, crit string, found map[string][]string) error {
+	uc, err := searchUpdates(ctx, crit, searchOptions{session: s, superseded: true})
+	if err != nil {
+		return err
+	}
+	defer uc.Close()
+
+	for _, u := range uc.Updates {
+		found[kbCacheKey(u.Identity)] = u.KBArticleIDs
+	}
+	return nil
+}
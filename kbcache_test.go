@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/cabbie/updates"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestKBCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cabbie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "kbcache.json")
+	now := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	c, err := loadKBCache(path)
+	if err != nil {
+		t.Fatalf("loadKBCache(%q) on missing file returned error: %v", path, err)
+	}
+	c.add(updates.Identity{UpdateID: "a", RevisionNumber: 1}, []string{"100"}, now)
+	c.addMiss(updates.Identity{UpdateID: "c", RevisionNumber: 1}, now)
+	if err := c.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadKBCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := got.lookup(updates.Identity{UpdateID: "A", RevisionNumber: 1}, now); !ok || e.NotFound || e.KBArticleIDs[0] != "100" {
+		t.Errorf("lookup(A, 1) = %+v, %t, want [100], true", e, ok)
+	}
+	if _, ok := got.lookup(updates.Identity{UpdateID: "a", RevisionNumber: 2}, now); ok {
+		t.Error("lookup(a, 2) hit an entry cached for revision 1")
+	}
+	if e, ok := got.lookup(updates.Identity{UpdateID: "c", RevisionNumber: 1}, now); !ok || !e.NotFound {
+		t.Errorf("lookup(c, 1) = %+v, %t, want a cached miss", e, ok)
+	}
+	if _, ok := got.lookup(updates.Identity{UpdateID: "c", RevisionNumber: 1}, now.Add(kbMissTTL+time.Hour)); ok {
+		t.Error("lookup(c, 1) returned a miss older than kbMissTTL")
+	}
+
+	ids := []updates.Identity{
+		{UpdateID: "a", RevisionNumber: 1},
+		{UpdateID: "a", RevisionNumber: 2},
+		{UpdateID: "b", RevisionNumber: 1},
+		{UpdateID: "B", RevisionNumber: 1},
+		{UpdateID: "c", RevisionNumber: 1},
+	}
+	want := []updates.Identity{{UpdateID: "a", RevisionNumber: 2}, {UpdateID: "b", RevisionNumber: 1}}
+	if diff := cmp.Diff(want, got.missing(ids, now)); diff != "" {
+		t.Errorf("missing() returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestKBCacheLegacy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cabbie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "kbcache.json")
+	legacy := `{"entries":{"a":{"revision_number":1,"kb_article_ids":["100"]}}}`
+	if err := ioutil.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := loadKBCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Revisions) != 0 {
+		t.Errorf("loadKBCache() of a cache keyed by UpdateID kept %d entries, want 0", len(c.Revisions))
+	}
+}
+
+func TestIdentityCriteria(t *testing.T) {
+	if got := identityCriteria(nil); len(got) != 0 {
+		t.Errorf("identityCriteria(nil) = %q, want none", got)
+	}
+
+	ids := make([]updates.Identity, kbSearchBatch+1)
+	for i := range ids {
+		ids[i] = updates.Identity{UpdateID: fmt.Sprintf("u%d", i), RevisionNumber: 200}
+	}
+	got := identityCriteria(ids)
+	if len(got) != 2 {
+		t.Fatalf("identityCriteria(%d ids) returned %d criteria, want 2", len(ids), len(got))
+	}
+	if n := strings.Count(got[0], " or ") + 1; n != kbSearchBatch {
+		t.Errorf("identityCriteria() first batch matches %d revisions, want %d", n, kbSearchBatch)
+	}
+	want := fmt.Sprintf("(UpdateID='u%d' and RevisionNumber=200)", kbSearchBatch)
+	if got[1] != want {
+		t.Errorf("identityCriteria() last batch = %q, want %q", got[1], want)
+	}
+}
@@ -23,42 +23,95 @@ import (
 	"flag"
 	"github.com/google/cabbie/search"
 	"github.com/google/cabbie/session"
+	"github.com/google/cabbie/updatecollection"
+	"github.com/google/cabbie/updates"
 	"github.com/google/subcommands"
 )
 
 // Available flags
 type listCmd struct {
-	hidden bool
+	hidden                bool
+	bulletins, save, from string
 }
 
 func (listCmd) Name() string     { return "list" }
 func (listCmd) Synopsis() string { return "list updates available for install." }
 func (listCmd) Usage() string {
-	return fmt.Sprintf("%s list [--hidden]\n", filepath.Base(os.Args[0]))
+	return fmt.Sprintf("%s list [--hidden] [--bulletins=\"<BulletinID>\"] [--save=\"<file>\" | --from=\"<file>\"]\n", filepath.Base(os.Args[0]))
 
 }
 func (c *listCmd) SetFlags(f *flag.FlagSet) {
 	f.BoolVar(&c.hidden, "hidden", false, "show updates that have been marked as hidden.")
+	f.StringVar(&c.bulletins, "bulletins", "", "only show updates associated with these comma separated security bulletin IDs.")
+	f.StringVar(&c.save, "save", "", "save the search result as JSON to this file for later offline analysis.")
+	f.StringVar(&c.from, "from", "", "list updates from a search result previously saved with --save instead of searching.")
 }
 
-func (c listCmd) Execute(_ context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+func (c listCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 	rc := subcommands.ExitSuccess
-	var requiredUpdates, optionalUpdates []string
-	var err error
-	requiredUpdates, optionalUpdates, err = listUpdates(c.hidden)
+	var bulletins []string
+	if c.bulletins != "" {
+		bulletins = strings.Split(c.bulletins, ",")
+	}
+	ups, err := c.updates(ctx)
 	if err != nil {
 		fmt.Printf("failed to get updates with error:\n%v\n", err)
 		rc = subcommands.ExitFailure
 	}
-	msg := fmt.Sprintf("Found %d required updates.\nRequired updates:\n%s\nOptional updates:\n%s\n",
-		len(requiredUpdates), strings.Join(requiredUpdates, "\n"), strings.Join(optionalUpdates, "\n"))
+	requiredUpdates, optionalUpdates, betaUpdates := classifyUpdates(ups, bulletins)
+	msg := fmt.Sprintf("Found %d required updates.\nRequired updates:\n%s\nOptional updates:\n%s\nBeta updates:\n%s\n",
+		len(requiredUpdates), strings.Join(requiredUpdates, "\n"), strings.Join(optionalUpdates, "\n"), strings.Join(betaUpdates, "\n"))
 	elog.Info(4, msg)
 	fmt.Print(msg)
 	return rc
 }
 
-// listUpdates queries the update server and returns a list of available updates
-func listUpdates(hidden bool) ([]string, []string, error) {
+// updates returns the updates to list, either loaded from a saved search result or from a new
+// search, which is saved when requested.
+func (c listCmd) updates(ctx context.Context) ([]*updates.Update, error) {
+	if c.from != "" {
+		f, err := os.Open(c.from)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open saved search result: %v", err)
+		}
+		defer f.Close()
+		return updates.ReadJSON(f)
+	}
+
+	uc, err := searchAvailable(ctx, c.hidden)
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+
+	if c.save != "" {
+		f, err := os.Create(c.save)
+		if err != nil {
+			return uc.Updates, fmt.Errorf("failed to create %q: %v", c.save, err)
+		}
+		defer f.Close()
+		if err := updates.WriteJSON(f, uc.Updates, meta.clone()); err != nil {
+			return uc.Updates, err
+		}
+	}
+	return uc.Updates, nil
+}
+
+// listUpdates queries the update server and returns lists of required, optional and beta updates,
+// optionally limited to those associated with one of the supplied security bulletins.
+func listUpdates(ctx context.Context, hidden bool, bulletins []string) ([]string, []string, []string, error) {
+	uc, err := searchAvailable(ctx, hidden)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer uc.Close()
+
+	req, opt, beta := classifyUpdates(uc.Updates, bulletins)
+	return req, opt, beta, nil
+}
+
+// searchAvailable queries the update server for available updates.
+func searchAvailable(ctx context.Context, hidden bool) (*updatecollection.Collection, error) {
 	// Set search criteria
 	c := search.BasicSearch + " OR Type='Driver' OR " + search.BasicSearch + " AND Type='Software'"
 	if hidden {
@@ -70,25 +123,41 @@ func listUpdates(hidden bool) ([]string, []string, error) {
 	// Start Windows update session
 	s, err := session.New()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create new Windows Update session: %v", err)
+		return nil, fmt.Errorf("failed to create new Windows Update session: %v", err)
 	}
 	defer s.Close()
 
 	q, err := search.NewSearcher(s, c, config.WSUSServers, config.EnableThirdParty)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create a new searcher object: %v", err)
+		return nil, fmt.Errorf("failed to create a new searcher object: %v", err)
 	}
 	defer q.Close()
 
-	elog.Info(002, fmt.Sprintf("Using search criteria: %s\n", q.Criteria))
-	uc, err := q.QueryUpdates()
+	searchLog.Info(002, fmt.Sprintf("Using search criteria: %s\n", q.Criteria))
+	sctx, cancel := operationContext(ctx, config.SearchTimeout)
+	defer cancel()
+
+	uc, err := q.QueryUpdatesContext(sctx)
+	reportBackoff(err)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error encountered when attempting to query for updates: %v", err)
+		return nil, fmt.Errorf("error encountered when attempting to query for updates: %v", err)
 	}
-	defer uc.Close()
+	return uc, nil
+}
 
-	var reqUpdates, optUpdates []string
-	for _, u := range uc.Updates {
+// classifyUpdates returns the titles of required, optional and beta updates, optionally limited to
+// those associated with one of the supplied security bulletins.
+func classifyUpdates(ups []*updates.Update, bulletins []string) ([]string, []string, []string) {
+	var reqUpdates, optUpdates, betaUpdates []string
+	for _, u := range ups {
+		if len(bulletins) > 0 && !u.InBulletins(bulletins) {
+			continue
+		}
+		// Beta updates are reported on their own as they are not installed by default.
+		if u.IsBeta {
+			betaUpdates = append(betaUpdates, u.Title)
+			continue
+		}
 		// Add to optional updates list if the update does not match the required categories.
 		if !u.InCategories(config.RequiredCategories) {
 			optUpdates = append(optUpdates, u.Title)
@@ -100,5 +169,5 @@ func listUpdates(hidden bool) ([]string, []string, error) {
 		}
 	}
 
-	return reqUpdates, optUpdates, nil
+	return reqUpdates, optUpdates, betaUpdates
 }
@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging provides leveled logging with per-module verbosity.
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Level is the verbosity of a log message.
+type Level int
+
+// Available log levels from least to most verbose.
+const (
+	Error Level = iota
+	Warning
+	Info
+	Debug
+)
+
+var levelNames = map[string]Level{
+	"error":   Error,
+	"warning": Warning,
+	"info":    Info,
+	"debug":   Debug,
+}
+
+func (l Level) String() string {
+	for n, v := range levelNames {
+		if v == l {
+			return n
+		}
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel returns the Level with the given name.
+func ParseLevel(s string) (Level, error) {
+	l, ok := levelNames[strings.ToLower(strings.TrimSpace(s))]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return l, nil
+}
+
+// Sink receives log messages that pass the level filter. debug.Log and eventlog.Log satisfy Sink.
+type Sink interface {
+	Error(eid uint32, msg string) error
+	Warning(eid uint32, msg string) error
+	Info(eid uint32, msg string) error
+}
+
+var (
+	mu           sync.RWMutex
+	sink         Sink
+	defaultLevel = Info
+	levels       = make(map[string]Level)
+)
+
+// SetSink sets where log messages are written. Messages are dropped until a sink is set.
+func SetSink(s Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sink = s
+}
+
+// SetLevels configures verbosity from a comma separated list of levels. An entry of the form
+// module=level sets the level of a single module, while a bare level sets the default for all other
+// modules, e.g. "warning,updatehistory=debug".
+func SetLevels(spec string) error {
+	def := Info
+	mods := make(map[string]Level)
+	for _, e := range strings.Split(spec, ",") {
+		if strings.TrimSpace(e) == "" {
+			continue
+		}
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) == 1 {
+			l, err := ParseLevel(kv[0])
+			if err != nil {
+				return err
+			}
+			def = l
+			continue
+		}
+		l, err := ParseLevel(kv[1])
+		if err != nil {
+			return fmt.Errorf("module %q: %v", kv[0], err)
+		}
+		mods[strings.ToLower(strings.TrimSpace(kv[0]))] = l
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	defaultLevel = def
+	levels = mods
+	return nil
+}
+
+// Logger writes messages for a single module.
+type Logger struct {
+	module string
+}
+
+// For returns the logger for a module.
+func For(module string) *Logger {
+	return &Logger{module: strings.ToLower(module)}
+}
+
+// Enabled reports whether messages at level are written for this module.
+func (l *Logger) Enabled(level Level) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	max, ok := levels[l.module]
+	if !ok {
+		max = defaultLevel
+	}
+	return level <= max
+}
+
+// Debug writes a debug message, which is recorded as an informational event.
+func (l *Logger) Debug(eid uint32, msg string) {
+	l.log(Debug, eid, msg)
+}
+
+// Info writes an informational message.
+func (l *Logger) Info(eid uint32, msg string) {
+	l.log(Info, eid, msg)
+}
+
+// Warning writes a warning message.
+func (l *Logger) Warning(eid uint32, msg string) {
+	l.log(Warning, eid, msg)
+}
+
+// Error writes an error message.
+func (l *Logger) Error(eid uint32, msg string) {
+	l.log(Error, eid, msg)
+}
+
+func (l *Logger) log(level Level, eid uint32, msg string) {
+	if !l.Enabled(level) {
+		return
+	}
+	mu.RLock()
+	s := sink
+	mu.RUnlock()
+	if s == nil {
+		return
+	}
+
+	switch level {
+	case Error:
+		s.Error(eid, msg)
+	case Warning:
+		s.Warning(eid, msg)
+	case Debug:
+		s.Info(eid, fmt.Sprintf("[%s] %s", l.module, msg))
+	default:
+		s.Info(eid, msg)
+	}
+}
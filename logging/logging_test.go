@@ -0,0 +1,95 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"testing"
+)
+
+type testSink struct {
+	msgs []string
+}
+
+func (s *testSink) Error(eid uint32, msg string) error {
+	s.msgs = append(s.msgs, "E: "+msg)
+	return nil
+}
+func (s *testSink) Warning(eid uint32, msg string) error {
+	s.msgs = append(s.msgs, "W: "+msg)
+	return nil
+}
+func (s *testSink) Info(eid uint32, msg string) error {
+	s.msgs = append(s.msgs, "I: "+msg)
+	return nil
+}
+
+func TestSetLevels(t *testing.T) {
+	defer SetLevels("")
+	for _, tt := range []struct {
+		spec   string
+		module string
+		level  Level
+		out    bool
+	}{
+		{"", "search", Info, true},
+		{"", "search", Debug, false},
+		{"updatehistory=debug", "updatehistory", Debug, true},
+		{"updatehistory=debug", "search", Debug, false},
+		{"warning,install=info", "download", Info, false},
+		{"warning,install=info", "install", Info, true},
+		{"error", "download", Warning, false},
+		{"error", "download", Error, true},
+	} {
+		if err := SetLevels(tt.spec); err != nil {
+			t.Fatalf("SetLevels(%q) returned error: %v", tt.spec, err)
+		}
+		if got := For(tt.module).Enabled(tt.level); got != tt.out {
+			t.Errorf("SetLevels(%q): For(%q).Enabled(%s) = %t, want %t", tt.spec, tt.module, tt.level, got, tt.out)
+		}
+	}
+}
+
+func TestSetLevelsError(t *testing.T) {
+	for _, spec := range []string{"loud", "search=loud"} {
+		if err := SetLevels(spec); err == nil {
+			t.Errorf("SetLevels(%q) returned nil error, want error", spec)
+		}
+	}
+}
+
+func TestLog(t *testing.T) {
+	s := &testSink{}
+	SetSink(s)
+	defer SetSink(nil)
+	defer SetLevels("")
+	if err := SetLevels("search=debug"); err != nil {
+		t.Fatal(err)
+	}
+
+	For("search").Debug(1, "criteria")
+	For("install").Debug(1, "dropped")
+	For("install").Warning(1, "slow")
+	For("install").Error(1, "failed")
+
+	want := []string{"I: [search] criteria", "W: slow", "E: failed"}
+	if len(s.msgs) != len(want) {
+		t.Fatalf("logged %q, want %q", s.msgs, want)
+	}
+	for i := range want {
+		if s.msgs[i] != want[i] {
+			t.Errorf("message %d = %q, want %q", i, s.msgs[i], want[i])
+		}
+	}
+}
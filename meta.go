@@ -0,0 +1,69 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// metaKey restricts metadata keys to identifiers that downstream systems can index.
+var metaKey = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]{0,63}$`)
+
+// runMeta is key=value metadata attached to a run, such as the change ticket that triggered it. It
+// is set with the repeatable --meta flag and recorded with the results of the run.
+type runMeta map[string]string
+
+// String returns the metadata as comma separated key=value pairs ordered by key.
+func (m runMeta) String() string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + m[k]
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set parses a single key=value pair. Keys must start with a letter and contain only letters, digits,
+// '_', '.' or '-'. Setting a key twice keeps the last value.
+func (m runMeta) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("metadata %q is not in the form key=value", s)
+	}
+	k := strings.TrimSpace(kv[0])
+	if !metaKey.MatchString(k) {
+		return fmt.Errorf("invalid metadata key %q: keys must match %s", k, metaKey)
+	}
+	m[k] = kv[1]
+	return nil
+}
+
+// clone returns the metadata to record with a result, or nil when no metadata was set.
+func (m runMeta) clone() runMeta {
+	if len(m) == 0 {
+		return nil
+	}
+	c := make(runMeta, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRunMetaSet(t *testing.T) {
+	tests := []struct {
+		desc    string
+		in      []string
+		want    runMeta
+		wantErr bool
+	}{
+		{
+			desc: "pairs",
+			in:   []string{"ticket=CHG12345", "wave=pilot"},
+			want: runMeta{"ticket": "CHG12345", "wave": "pilot"},
+		},
+		{
+			desc: "value containing equals",
+			in:   []string{"query=a=b"},
+			want: runMeta{"query": "a=b"},
+		},
+		{
+			desc: "last value wins",
+			in:   []string{"wave=pilot", "wave=broad"},
+			want: runMeta{"wave": "broad"},
+		},
+		{
+			desc:    "missing value",
+			in:      []string{"ticket"},
+			want:    runMeta{},
+			wantErr: true,
+		},
+		{
+			desc:    "invalid key",
+			in:      []string{"1ticket=CHG12345"},
+			want:    runMeta{},
+			wantErr: true,
+		},
+		{
+			desc:    "empty key",
+			in:      []string{"=CHG12345"},
+			want:    runMeta{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		m := runMeta{}
+		var err error
+		for _, s := range tt.in {
+			if err = m.Set(s); err != nil {
+				break
+			}
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Set(%v) error = %v, want error: %t", tt.desc, tt.in, err, tt.wantErr)
+		}
+		if diff := cmp.Diff(tt.want, m); diff != "" {
+			t.Errorf("%s: Set(%v) returned diff (-want +got):\n%s", tt.desc, tt.in, diff)
+		}
+	}
+}
+
+func TestRunMetaString(t *testing.T) {
+	m := runMeta{"wave": "pilot", "ticket": "CHG12345"}
+	if got, want := m.String(), "ticket=CHG12345,wave=pilot"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := (runMeta{}).clone(); got != nil {
+		t.Errorf("clone() of empty metadata = %v, want nil", got)
+	}
+}
@@ -118,3 +118,29 @@ func (s *String) Set(value string) error {
 	s.value = value
 	return nil
 }
+
+// Float implements a Float-type metric.
+type Float struct {
+	value float64
+	mu    sync.Mutex
+	data  *metricData
+}
+
+// NewFloat sets the metric to a new Float value.
+func NewFloat(name, service string) (*Float, error) {
+	return &Float{
+		data: &metricData{
+			name:    name,
+			service: service,
+		},
+	}, nil
+}
+
+// Set sets the metric to a new float value.
+func (f *Float) Set(value float64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.value = value
+	return nil
+}
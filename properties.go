@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows,debug
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"flag"
+	"github.com/google/cabbie/cablib"
+	"github.com/go-ole/go-ole"
+	"github.com/google/subcommands"
+)
+
+// The properties command is only built with the debug tag as it exists to help develop Cabbie.
+func init() {
+	subcommands.Register(&propertiesCmd{}, "Debugging")
+}
+
+// Available flags
+type propertiesCmd struct {
+	kb      string
+	history bool
+}
+
+func (propertiesCmd) Name() string { return "properties" }
+func (propertiesCmd) Synopsis() string {
+	return "List the COM properties exposed by an update or history entry."
+}
+func (propertiesCmd) Usage() string {
+	return fmt.Sprintf("%s properties [--kb=\"<KBNumber>\" | --history]\n", filepath.Base(os.Args[0]))
+}
+func (c *propertiesCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.kb, "kb", "", "KB number of the update to inspect, defaults to the first update found.")
+	f.BoolVar(&c.history, "history", false, "Inspect the most recent update history entry instead of an update.")
+}
+
+func (c propertiesCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	var item *ole.IDispatch
+	if c.history {
+		h, err := history(ctx, nil)
+		if err != nil {
+			fmt.Printf("Failed to get update history: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		defer h.Close()
+		if len(h.Entries) > 0 {
+			item = h.Entries[0].Item
+		}
+	} else {
+		uc, err := searchUpdates(ctx, "IsInstalled=0 or IsInstalled=1", searchOptions{})
+		if err != nil {
+			fmt.Printf("Failed to query for updates: %v\n", err)
+			return subcommands.ExitFailure
+		}
+		defer uc.Close()
+		kbs := NewKBSet(c.kb)
+		for _, u := range uc.Updates {
+			if kbs.Size() == 0 || kbs.Search(u.KBArticleIDs) {
+				fmt.Printf("Properties of %s:\n", u.Title)
+				item = u.Item
+				break
+			}
+		}
+	}
+	if item == nil {
+		fmt.Println("Nothing found to inspect.")
+		return subcommands.ExitFailure
+	}
+
+	props, err := cablib.DumpProperties(item)
+	if err != nil {
+		fmt.Printf("Failed to enumerate properties: %v\n", err)
+		return subcommands.ExitFailure
+	}
+	names := make([]string, 0, len(props))
+	for n := range props {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fmt.Printf("  %s: %s\n", n, props[n])
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"flag"
+	"github.com/google/cabbie/updatehistory"
+	"github.com/google/cabbie/updates"
+	"github.com/google/subcommands"
+)
+
+// Available flags
+type removableCmd struct {
+}
+
+func (removableCmd) Name() string { return "removable" }
+func (removableCmd) Synopsis() string {
+	return "List installed updates that can be uninstalled and those that are permanent."
+}
+func (removableCmd) Usage() string {
+	return fmt.Sprintf("%s removable\n", filepath.Base(os.Args[0]))
+}
+func (c *removableCmd) SetFlags(f *flag.FlagSet) {}
+
+func (c removableCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	removable, permanent, err := installedRemovability(ctx)
+	if err != nil {
+		fmt.Printf("Failed to get installed updates: %v\n", err)
+		elog.Error(116, fmt.Sprintf("Failed to get installed updates: %v", err))
+		return subcommands.ExitFailure
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Removable\tInstalled\tKBs\tTitle")
+	for _, rs := range [][]removability{removable, permanent} {
+		for _, r := range rs {
+			installed := "unknown"
+			if !r.Installed.IsZero() {
+				installed = r.Installed.Format("2006-01-02")
+			}
+			fmt.Fprintf(w, "%t\t%s\t%s\t%s\n", r.Removable, installed, strings.Join(r.KBArticleIDs, ","), r.Title)
+		}
+	}
+	w.Flush()
+	fmt.Printf("\n%d removable and %d permanent updates installed.\n", len(removable), len(permanent))
+	return subcommands.ExitSuccess
+}
+
+// removability describes whether an installed update can be uninstalled.
+type removability struct {
+	Title        string
+	KBArticleIDs []string
+	Removable    bool
+	// Installed is the date of the last successful installation recorded in history, if any.
+	Installed time.Time
+}
+
+// installedRemovability searches installed updates and returns those that can be uninstalled and
+// those that are permanent, along with their install date from the update history.
+func installedRemovability(ctx context.Context) ([]removability, []removability, error) {
+	uc, err := searchUpdates(ctx, "IsInstalled=1", searchOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer uc.Close()
+
+	h, err := history(ctx, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get update history: %v", err)
+	}
+	defer h.Close()
+
+	removable, permanent := removabilityFor(uc.Updates, h.Entries)
+	return removable, permanent, nil
+}
+
+// removabilityFor partitions installed updates by whether they can be uninstalled, each ordered by
+// title, using the most recent successful installation in entries as the install date.
+func removabilityFor(ups []*updates.Update, entries []*updatehistory.Entry) ([]removability, []removability) {
+	installed := make(map[string]time.Time)
+	for _, e := range entries {
+		if e.Operation != updates.OperationInstallation || !e.ResultCode.Succeeded() {
+			continue
+		}
+		if d := installed[e.UpdateIdentity.UpdateID]; e.Date.After(d) {
+			installed[e.UpdateIdentity.UpdateID] = e.Date
+		}
+	}
+
+	var removable, permanent []removability
+	for _, u := range ups {
+		r := removability{
+			Title:        u.Title,
+			KBArticleIDs: u.KBArticleIDs,
+			Removable:    u.IsUninstallable,
+			Installed:    installed[u.Identity.UpdateID],
+		}
+		if r.Removable {
+			removable = append(removable, r)
+			continue
+		}
+		permanent = append(permanent, r)
+	}
+
+	for _, rs := range [][]removability{removable, permanent} {
+		sort.Slice(rs, func(i, j int) bool { return rs[i].Title < rs[j].Title })
+	}
+	return removable, permanent
+}
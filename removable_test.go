@@ -0,0 +1,50 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/cabbie/updatehistory"
+	"github.com/google/cabbie/updates"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRemovabilityFor(t *testing.T) {
+	first := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	ups := []*updates.Update{
+		{Title: "b", Identity: updates.Identity{UpdateID: "b"}, IsUninstallable: true},
+		{Title: "c", Identity: updates.Identity{UpdateID: "c"}},
+		{Title: "a", Identity: updates.Identity{UpdateID: "a"}, IsUninstallable: true},
+	}
+	entries := []*updatehistory.Entry{
+		{UpdateIdentity: updates.Identity{UpdateID: "a"}, Operation: 1, ResultCode: 2, Date: first},
+		{UpdateIdentity: updates.Identity{UpdateID: "a"}, Operation: 1, ResultCode: 2, Date: second},
+		{UpdateIdentity: updates.Identity{UpdateID: "b"}, Operation: 1, ResultCode: 4, Date: second},
+		{UpdateIdentity: updates.Identity{UpdateID: "c"}, Operation: 1, ResultCode: 3, Date: first},
+	}
+
+	removable, permanent := removabilityFor(ups, entries)
+	if diff := cmp.Diff([]removability{
+		{Title: "a", Removable: true, Installed: second},
+		{Title: "b", Removable: true},
+	}, removable); diff != "" {
+		t.Errorf("removabilityFor() removable diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]removability{{Title: "c", Installed: first}}, permanent); diff != "" {
+		t.Errorf("removabilityFor() permanent diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"flag"
+	"github.com/google/cabbie/session"
+	"github.com/google/cabbie/updatecollection"
+	"github.com/google/subcommands"
+)
+
+// Available flags
+type retryCmd struct {
+	force bool
+}
+
+func (retryCmd) Name() string { return "retry" }
+func (retryCmd) Synopsis() string {
+	return "Retry downloading updates that failed to download in a previous run."
+}
+func (retryCmd) Usage() string {
+	return fmt.Sprintf("%s retry [--force]\n", filepath.Base(os.Args[0]))
+}
+func (c *retryCmd) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&c.force, "force", false, "Retry downloads even during configured active hours.")
+}
+
+func (c retryCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	if !c.force && inActiveHours(time.Now(), config.ActiveHoursStart, config.ActiveHoursEnd) {
+		msg := fmt.Sprintf("Deferring retry of failed downloads during active hours (%02d:00-%02d:00). Use --force to override.",
+			config.ActiveHoursStart, config.ActiveHoursEnd)
+		fmt.Println(msg)
+		downloadLog.Info(002, msg)
+		return subcommands.ExitSuccess
+	}
+	results, err := retryFailedDownloads(ctx)
+	if err != nil {
+		fmt.Printf("Failed to retry downloads: %v\n", err)
+		elog.Error(114, fmt.Sprintf("Failed to retry downloads: %v", err))
+		return subcommands.ExitFailure
+	}
+	if len(results) == 0 {
+		fmt.Println("No failed downloads to retry.")
+		return subcommands.ExitSuccess
+	}
+
+	rc := subcommands.ExitSuccess
+	for _, r := range results {
+		outcome := "succeeded"
+		if !r.succeeded() {
+			outcome = fmt.Sprintf("failed (ResultCode: %d) %s", r.ResultCode, r.Error)
+			rc = subcommands.ExitFailure
+		}
+		fmt.Printf("Retried download of %s [%s]: %s\n", r.Title, r.UpdateID, outcome)
+	}
+	return rc
+}
+
+// retryFailedDownloads re-attempts the downloads recorded as failed in the state file, returning the
+// new outcome of each retried update.
+func retryFailedDownloads(ctx context.Context) ([]*updateResult, error) {
+	st, err := loadState(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	failed := st.failedDownloads()
+	if len(failed) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, len(failed))
+	for i, f := range failed {
+		ids[i] = fmt.Sprintf("UpdateID='%s'", f.UpdateID)
+	}
+
+	// Start Windows update session
+	s, err := session.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new Windows Update session: %v", err)
+	}
+	defer s.Close()
+
+	uc, err := searchUpdates(ctx, strings.Join(ids, " or "), searchOptions{session: s})
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+
+	var results []*updateResult
+	for _, u := range uc.Updates {
+		c, err := updatecollection.New()
+		if err != nil {
+			elog.Error(202, fmt.Sprintf("Failed to create collection: %v", err))
+			continue
+		}
+		c.Add(u.Item)
+
+		downloadLog.Info(002, fmt.Sprintf("Retrying download of update:\n%v", u))
+		rc, err := downloadCollection(ctx, s, c)
+		results = append(results, st.recordDownload(u, rc, err))
+		c.Close()
+	}
+
+	// Updates that can no longer be found are no longer offered and have nothing left to retry.
+	found := make(map[string]bool)
+	for _, u := range uc.Updates {
+		found[u.Identity.UpdateID] = true
+	}
+	for _, f := range failed {
+		if !found[f.UpdateID] {
+			elog.Info(002, fmt.Sprintf("Update %s [%s] is no longer available, removing it from the retry list.", f.Title, f.UpdateID))
+			delete(st.Downloads, f.UpdateID)
+		}
+	}
+
+	return results, st.save(stateFile)
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"flag"
+	"github.com/google/cabbie/updates"
+	"github.com/google/subcommands"
+)
+
+// Available flags
+type revisionsCmd struct {
+	kb string
+}
+
+func (revisionsCmd) Name() string { return "revisions" }
+func (revisionsCmd) Synopsis() string {
+	return "List the revisions of a KB known to the Windows Update Agent."
+}
+func (revisionsCmd) Usage() string {
+	return fmt.Sprintf("%s revisions --kb=\"<KBNumber>\"\n", filepath.Base(os.Args[0]))
+}
+func (c *revisionsCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&c.kb, "kb", "", "KB number in the form of 1234567.")
+}
+
+func (c revisionsCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	kbs := NewKBSet(c.kb)
+	if kbs.Size() != 1 {
+		fmt.Printf("%s\nUsage: %s\n", c.Synopsis(), c.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	revs, err := kbRevisions(ctx, kbs)
+	if err != nil {
+		fmt.Printf("Failed to get revisions for KB %s: %v\n", c.kb, err)
+		elog.Error(115, fmt.Sprintf("Failed to get revisions for KB %s: %v", c.kb, err))
+		return subcommands.ExitFailure
+	}
+	if len(revs) == 0 {
+		fmt.Printf("No updates found for KB %s.\n", c.kb)
+		return subcommands.ExitSuccess
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "UpdateID\tRevision\tApproval\tSuperseded\tInstalled\tTitle")
+	for _, r := range revs {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%t\t%t\t%s\n", r.UpdateID, r.RevisionNumber, r.Approval, r.Superseded, r.Installed, r.Title)
+	}
+	w.Flush()
+	return subcommands.ExitSuccess
+}
+
+// revision describes a single revision of an update.
+type revision struct {
+	updates.Identity
+	Title      string
+	Approval   string
+	Superseded bool
+	Installed  bool
+}
+
+// kbRevisions searches for every installed or available update, including superseded ones, and
+// returns the revisions matching the KB.
+func kbRevisions(ctx context.Context, kbs KBSet) ([]revision, error) {
+	uc, err := searchUpdates(ctx, "IsInstalled=0 or IsInstalled=1", searchOptions{superseded: true})
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+
+	return revisionsFor(kbs, uc.Updates), nil
+}
+
+// revisionsFor returns the updates matching the KBs ordered by revision number. An update is
+// superseded when any other update in ups reports superseding it.
+func revisionsFor(kbs KBSet, ups []*updates.Update) []revision {
+	superseded := make(map[string]bool)
+	for _, u := range ups {
+		for _, id := range u.SupersededUpdateIDs {
+			superseded[id] = true
+		}
+	}
+
+	var revs []revision
+	for _, u := range ups {
+		if !kbs.Search(u.KBArticleIDs) {
+			continue
+		}
+		revs = append(revs, revision{
+			Identity:   u.Identity,
+			Title:      u.Title,
+			Approval:   updates.DeploymentActionName(u.DeploymentAction),
+			Superseded: superseded[u.Identity.UpdateID],
+			Installed:  u.IsInstalled,
+		})
+	}
+
+	sort.SliceStable(revs, func(i, j int) bool { return revs[i].RevisionNumber < revs[j].RevisionNumber })
+	return revs
+}
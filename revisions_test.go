@@ -0,0 +1,37 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"testing"
+
+	"github.com/google/cabbie/updates"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRevisionsFor(t *testing.T) {
+	ups := []*updates.Update{
+		{Title: "KB2 rev 200", Identity: updates.Identity{UpdateID: "b", RevisionNumber: 200}, KBArticleIDs: []string{"2"}, DeploymentAction: 1},
+		{Title: "KB2 rev 100", Identity: updates.Identity{UpdateID: "a", RevisionNumber: 100}, KBArticleIDs: []string{"2"}, IsInstalled: true},
+		{Title: "KB3", Identity: updates.Identity{UpdateID: "c", RevisionNumber: 1}, KBArticleIDs: []string{"3"}, SupersededUpdateIDs: []string{"a"}},
+	}
+	want := []revision{
+		{Identity: updates.Identity{UpdateID: "a", RevisionNumber: 100}, Title: "KB2 rev 100", Approval: "None", Superseded: true, Installed: true},
+		{Identity: updates.Identity{UpdateID: "b", RevisionNumber: 200}, Title: "KB2 rev 200", Approval: "Installation"},
+	}
+	got := revisionsFor(NewKBSet("KB2"), ups)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("revisionsFor() returned diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/cabbie/search"
+	"github.com/google/cabbie/servicemgr"
+	"github.com/google/cabbie/session"
+	"github.com/google/cabbie/updatecollection"
+	"github.com/google/cabbie/wsus"
+)
+
+// searchOptions adjusts a search made by searchUpdates. The zero value searches Cabbie's configured
+// source in a new session.
+type searchOptions struct {
+	// session is the Windows Update session to search in, so consecutive searches can share one. A
+	// new session is created for the search when nil.
+	session *session.UpdateSession
+	// superseded includes updates that may be superseded by other updates.
+	superseded bool
+	// service is the registered update service to search instead of the configured source.
+	service *servicemgr.Service
+}
+
+// searchUpdates searches for the updates matching criteria within config.SearchTimeout. The caller
+// must Close the returned collection.
+func searchUpdates(ctx context.Context, criteria string, opts searchOptions) (*updatecollection.Collection, error) {
+	s := opts.session
+	if s == nil {
+		// Start Windows update session
+		var err error
+		if s, err = session.New(); err != nil {
+			return nil, fmt.Errorf("failed to create new Windows Update session: %v", err)
+		}
+		defer s.Close()
+	}
+
+	q, err := search.NewSearcher(s, criteria, config.WSUSServers, config.EnableThirdParty)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a new searcher object: %v", err)
+	}
+	defer q.Close()
+	q.IncludePotentiallySupersededUpdates = opts.superseded
+	if svc := opts.service; svc != nil {
+		q.ServerSelection, q.ServiceID = wsus.Others, string(svc.ID)
+		if svc.IsManaged {
+			q.ServerSelection, q.ServiceID = wsus.ManagedServer, string(servicemgr.Default)
+		}
+	}
+
+	sctx, cancel := operationContext(ctx, config.SearchTimeout)
+	defer cancel()
+
+	uc, err := q.QueryUpdatesContext(sctx)
+	if err != nil {
+		return nil, fmt.Errorf("error encountered when attempting to query for updates: %v", err)
+	}
+	return uc, nil
+}
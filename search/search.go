@@ -18,10 +18,15 @@
 package search
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/cabbie/cablib"
 	"github.com/google/cabbie/errors"
+	"github.com/google/cabbie/logging"
 	"github.com/google/cabbie/servicemgr"
 	"github.com/google/cabbie/session"
 	"github.com/google/cabbie/updatecollection"
@@ -32,6 +37,78 @@ import (
 	"github.com/go-ole/go-ole/oleutil"
 )
 
+var (
+	backoffMu sync.Mutex
+	// backoff is the latest backoff requested by an update server, and consecutive how many
+	// searches in a row were asked to back off.
+	backoff     *errors.BackoffError
+	consecutive int
+)
+
+// stale is set by InvalidateCache until the next search runs.
+var stale int32
+
+func init() {
+	servicemgr.OnChange(InvalidateCache)
+}
+
+// InvalidateCache discards the state carried between searches so the next search reflects the
+// current client configuration. Any server backoff is cleared, as it applied to the previous server,
+// and the next search is forced online instead of being answered from metadata cached by the Windows
+// Update Agent. Call it after changing WSUS targeting or update service registrations.
+//
+// The forced search downloads the full update metadata from the server, which against Windows
+// Update or a large WSUS catalog can add several minutes and significant network traffic to the
+// next search.
+func InvalidateCache() {
+	backoffMu.Lock()
+	backoff, consecutive = nil, 0
+	backoffMu.Unlock()
+	atomic.StoreInt32(&stale, 1)
+	log.Debug(2, "Search cache invalidated, the next search will be forced online")
+}
+
+// Backoff returns the backoff requested by an update server that is still in effect, or nil when
+// searches may proceed.
+func Backoff() *errors.BackoffError {
+	backoffMu.Lock()
+	defer backoffMu.Unlock()
+	if backoff == nil || !time.Now().Before(backoff.Until) {
+		return nil
+	}
+	b := *backoff
+	b.Pending = true
+	return &b
+}
+
+// recordBackoff returns a BackoffError when code asks clients to back off, remembering it so that
+// later searches wait. Any other result resets the consecutive backoff count.
+func recordBackoff(code errors.UpdateError, now time.Time) *errors.BackoffError {
+	backoffMu.Lock()
+	defer backoffMu.Unlock()
+	d, ok := errors.Backoff(code, consecutive)
+	if !ok {
+		backoff, consecutive = nil, 0
+		return nil
+	}
+	consecutive++
+	backoff = &errors.BackoffError{Code: code, RetryAfter: d, Until: now.Add(d)}
+	b := *backoff
+	return &b
+}
+
+// hResult returns the HResult carried by an error from a COM call.
+func hResult(err error) errors.UpdateError {
+	oe, ok := err.(*ole.OleError)
+	if !ok {
+		return 0
+	}
+	if ei, ok := oe.SubError().(ole.EXCEPINFO); ok && ei.SCODE() != 0 {
+		return errors.UpdateError(ei.SCODE())
+	}
+	return errors.UpdateError(oe.Code())
+}
+
 // CategoryID represents the category to which an update belongs.
 // GUIDs can be found here:
 // https://docs.microsoft.com/en-us/previous-versions/windows/desktop/ff357803(v=vs.85)?redirectedfrom=MSDN
@@ -43,17 +120,19 @@ const (
 	// Connectors GUID
 	Connectors CategoryID = "434DE588-ED14-48F5-8EED-A15E09A991F6"
 	// CriticalUpdates GUID
-	CriticalUpdates CategoryID = "E6CF1350-C01B-414D-A61F-263D14D133B4"
+	CriticalUpdates CategoryID = updates.CategoryCriticalUpdates
 	// DefinitionUpdates GUID
-	DefinitionUpdates CategoryID = "E0789628-CE08-4437-BE74-2495B842F43B"
+	DefinitionUpdates CategoryID = updates.CategoryDefinitionUpdates
 	// DeveloperKits GUID
 	DeveloperKits CategoryID = "E140075D-8433-45C3-AD87-E72345B36078"
+	// Drivers GUID
+	Drivers CategoryID = updates.CategoryDrivers
 	// FeaturePacks GUID
 	FeaturePacks CategoryID = "B54E7D24-7ADD-428F-8B75-90A396FA584F"
 	// Guidance GUID
 	Guidance CategoryID = "9511D615-35B2-47BB-927F-F73D8E9260BB"
 	// SecurityUpdates GUID
-	SecurityUpdates CategoryID = "0FA1201D-4330-4FA8-8AE9-B877473B6441"
+	SecurityUpdates CategoryID = updates.CategorySecurityUpdates
 	// ServicePacks GUID
 	ServicePacks CategoryID = "68C5B0A3-D1A6-4553-AE49-01D3A7827828"
 	// Tools GUID
@@ -66,6 +145,8 @@ const (
 	BasicSearch = "IsInstalled=0 and DeploymentAction='Installation'"
 )
 
+var log = logging.For("search")
+
 // Searcher describes search properties
 // ISearchResult interface be found here: https://docs.microsoft.com/en-us/windows/desktop/api/wuapi/nn-wuapi-isearchresult
 type Searcher struct {
@@ -81,6 +162,10 @@ type Searcher struct {
 }
 
 func (s *Searcher) configureRegistry() error {
+	// Searches are permitted in read-only mode using the existing client configuration.
+	if cablib.ReadOnly() {
+		return nil
+	}
 	k, err := registry.OpenKey(registry.LOCAL_MACHINE, cablib.WUReg, registry.SET_VALUE)
 	if err != nil && err != registry.ErrNotExist {
 		return err
@@ -102,7 +187,12 @@ func (s *Searcher) configureRegistry() error {
 
 // NewSearcher creates a default searcher object
 func NewSearcher(us *session.UpdateSession, criteria string, servers []string, thirdParty uint64) (*Searcher, error) {
+	before, _ := wsus.ConfiguredServer()
 	w, errors := wsus.Init(servers)
+	if after, err := wsus.ConfiguredServer(); err == nil && after != before {
+		log.Info(2, fmt.Sprintf("WSUS server changed from %q to %q", before, after))
+		InvalidateCache()
+	}
 	if errors != nil {
 		return nil, fmt.Errorf("Errors Initializing WSUS:\n%v", errors)
 	}
@@ -129,10 +219,31 @@ func NewSearcher(us *session.UpdateSession, criteria string, servers []string, t
 
 // QueryUpdates uses the specified criteria to look up updates.
 func (s *Searcher) QueryUpdates() (*updatecollection.Collection, error) {
+	return s.QueryUpdatesContext(context.Background())
+}
+
+// QueryUpdatesContext uses the specified criteria to look up updates, aborting the search if
+// the context is cancelled or its deadline passes before it completes.
+//
+// Searches are not attempted while a backoff requested by an update server is in effect; a
+// *errors.BackoffError is returned instead.
+func (s *Searcher) QueryUpdatesContext(ctx context.Context) (*updatecollection.Collection, error) {
+	if b := Backoff(); b != nil {
+		log.Debug(2, fmt.Sprintf("Skipping search: %v", b))
+		return nil, b
+	}
 	if err := s.configureRegistry(); err != nil {
 		return nil, fmt.Errorf("failed to set registry values: %v", err)
 	}
 
+	if atomic.CompareAndSwapInt32(&stale, 1, 0) {
+		log.Debug(2, "Forcing an online search after a configuration change")
+		if _, err := oleutil.PutProperty(s.IUpdateSearcher, "Online", true); err != nil {
+			atomic.StoreInt32(&stale, 1)
+			return nil, fmt.Errorf("failed to set Online property: \n %v", err)
+		}
+	}
+
 	// Set Update searcher properties
 	if _, err := oleutil.PutProperty(s.IUpdateSearcher, "ServerSelection", s.ServerSelection); err != nil {
 		return nil, fmt.Errorf("failed to set server selection property: \n %v", err)
@@ -143,14 +254,26 @@ func (s *Searcher) QueryUpdates() (*updatecollection.Collection, error) {
 		return nil, fmt.Errorf("failed to set serviceID property: \n %v", err)
 	}
 
+	// Include updates that may be superseded by other updates
+	if _, err := oleutil.PutProperty(s.IUpdateSearcher, "IncludePotentiallySupersededUpdates", s.IncludePotentiallySupersededUpdates); err != nil {
+		return nil, fmt.Errorf("failed to set IncludePotentiallySupersededUpdates property: \n %v", err)
+	}
+
+	// Verify a managed server can be reached so it is not mistaken for an empty search.
+	if s.ServerSelection == wsus.ManagedServer {
+		if err := wsus.CheckConnectivity(ctx); err != nil {
+			return nil, fmt.Errorf("WSUS precheck failed: %v", err)
+		}
+	}
+
 	// Search for updates
-	usr, err := oleutil.CallMethod(s.IUpdateSearcher, "Search", s.Criteria)
+	log.Debug(2, fmt.Sprintf("Searching with criteria %q, ServerSelection %d, ServiceID %q", s.Criteria, s.ServerSelection, s.ServiceID))
+	usr, err := s.search(ctx)
 	if err != nil {
-		s.SearchHResult = fmt.Sprintf("%s", errors.UpdateError(usr.Val))
-		return nil, fmt.Errorf("search error: [%s] [%v]", s.SearchHResult, err)
+		return nil, err
 	}
 	s.SearchHResult = fmt.Sprintf("%s", errors.UpdateError(cablib.S_OK))
-	s.ISearchResult = usr.ToIDispatch()
+	s.ISearchResult = usr
 
 	// Get list of returned updates
 	upd, err := oleutil.GetProperty(s.ISearchResult, "Updates")
@@ -176,6 +299,7 @@ func (s *Searcher) QueryUpdates() (*updatecollection.Collection, error) {
 
 		up, errors := updates.New(itemd)
 		if errors != nil {
+			log.Debug(2, fmt.Sprintf("Errors expanding update %d of %d: %v", i+1, count, errors))
 			return nil, fmt.Errorf("errors in update enumeration: %v", errors)
 		}
 		updd.Updates[i] = up
@@ -184,6 +308,33 @@ func (s *Searcher) QueryUpdates() (*updatecollection.Collection, error) {
 	return &updd, nil
 }
 
+func (s *Searcher) search(ctx context.Context) (*ole.IDispatch, error) {
+	j, err := oleutil.CallMethod(s.IUpdateSearcher, "BeginSearch", s.Criteria, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("search error: %v", err)
+	}
+	job := j.ToIDispatch()
+	defer job.Release()
+	defer oleutil.CallMethod(job, "CleanUp")
+
+	if err := cablib.WaitForJob(ctx, job, "search"); err != nil {
+		return nil, fmt.Errorf("search error: %v", err)
+	}
+
+	usr, err := oleutil.CallMethod(s.IUpdateSearcher, "EndSearch", job)
+	if err != nil {
+		code := hResult(err)
+		s.SearchHResult = fmt.Sprintf("%s", code)
+		if b := recordBackoff(code, time.Now()); b != nil {
+			log.Warning(2, fmt.Sprintf("Update server requested backoff until %s: %s", b.Until.Format(time.RFC3339), code))
+			return nil, b
+		}
+		return nil, fmt.Errorf("search error: [%s] [%v]", s.SearchHResult, err)
+	}
+	recordBackoff(errors.SUCCESS, time.Now())
+	return usr.ToIDispatch(), nil
+}
+
 // ResultCode gets an OperationResultCode enumeration that specifies the result of a search.
 // Possible Result codes:
 // 0 - (orcNotStarted)	The operation is not started.
@@ -79,6 +79,9 @@ func (c serviceCmd) Execute(ctx context.Context, flags *flag.FlagSet, args ...in
 }
 
 func installService(name, desc string) error {
+	if err := cablib.CheckWritable("install service"); err != nil {
+		return err
+	}
 	exepath, err := filepath.Abs(cablib.ExePath)
 	if err != nil {
 		return err
@@ -153,6 +156,9 @@ func installService(name, desc string) error {
 }
 
 func removeService(name string) error {
+	if err := cablib.CheckWritable("remove service"); err != nil {
+		return err
+	}
 	m, err := mgr.Connect()
 	if err != nil {
 		return err
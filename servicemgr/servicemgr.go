@@ -17,6 +17,9 @@
 package servicemgr
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/google/cabbie/cablib"
 	"github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
@@ -51,6 +54,49 @@ const (
 	WSUS ServiceID = "3DA21691-E39D-4da6-8A4B-B43877BCB1B7"
 )
 
+// onChange is called after a service registration changes.
+var onChange func()
+
+// OnChange sets fn to be called whenever AddService or RemoveService changes a registration.
+func OnChange(fn func()) {
+	onChange = fn
+}
+
+func changed() {
+	if onChange != nil {
+		onChange()
+	}
+}
+
+// Service describes an update service registered with Windows Update Agent.
+// https://docs.microsoft.com/en-us/windows/win32/api/wuapi/nn-wuapi-iupdateservice
+type Service struct {
+	ID   ServiceID
+	Name string
+	// IsManaged is set for the service backed by a WSUS server.
+	IsManaged bool
+	// IsDefaultAUService is set for the service Automatic Updates uses by default.
+	IsDefaultAUService bool
+}
+
+// knownNames are friendly names of well known services, used when a service reports no name.
+var knownNames = map[ServiceID]string{
+	WindowsUpdate:   "Windows Update",
+	MicrosoftUpdate: "Microsoft Update",
+	WindowsStore:    "Windows Store",
+	WSUS:            "Windows Server Update Service",
+}
+
+// Name returns the friendly name of a well known ServiceID, or the ServiceID itself.
+func Name(s ServiceID) string {
+	for id, n := range knownNames {
+		if strings.EqualFold(string(id), string(s)) {
+			return n
+		}
+	}
+	return string(s)
+}
+
 // InitMgrService creates an update service manager object.
 func InitMgrService() (*ServiceManager, error) {
 	if err := cablib.InitializeCOM(); err != nil {
@@ -67,8 +113,14 @@ func InitMgrService() (*ServiceManager, error) {
 // cabinet file (.cab).
 // More info can be found at https://docs.microsoft.com/en-us/windows/win32/api/wuapi/nf-wuapi-iupdateservicemanager2-addservice2
 func (m *ServiceManager) AddService(s ServiceID) error {
-	_, err := oleutil.CallMethod(m.ServiceManager, "AddService2", string(s), 7, "")
-	return err
+	if err := cablib.CheckWritable("add update service"); err != nil {
+		return err
+	}
+	if _, err := oleutil.CallMethod(m.ServiceManager, "AddService2", string(s), 7, ""); err != nil {
+		return err
+	}
+	changed()
+	return nil
 }
 
 // QueryServiceRegistration verifies if a serviceID has been registered with Windows Update Agent.
@@ -97,10 +149,69 @@ func (m *ServiceManager) QueryServiceRegistration(s ServiceID) (bool, error) {
 	return false, nil
 }
 
+// Services enumerates the services registered with Windows Update Agent.
+func (m *ServiceManager) Services() ([]Service, error) {
+	s, err := oleutil.GetProperty(m.ServiceManager, "Services")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Services property: %v", err)
+	}
+	sd := s.ToIDispatch()
+	defer sd.Release()
+
+	count, err := cablib.Count(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	var svcs []Service
+	for i := 0; i < count; i++ {
+		item, err := oleutil.GetProperty(sd, "Item", i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get service %d: %v", i, err)
+		}
+		itemd := item.ToIDispatch()
+		svc, err := toService(itemd)
+		itemd.Release()
+		if err != nil {
+			return nil, err
+		}
+		svcs = append(svcs, svc)
+	}
+	return svcs, nil
+}
+
+func toService(d *ole.IDispatch) (Service, error) {
+	var svc Service
+	props := make(map[string]interface{})
+	for _, p := range []string{"ServiceID", "Name", "IsManaged", "IsDefaultAUService"} {
+		v, err := oleutil.GetProperty(d, p)
+		if err != nil {
+			return svc, fmt.Errorf("failed to get service %s: %v", p, err)
+		}
+		props[p] = v.Value()
+		v.Clear()
+	}
+	id, _ := props["ServiceID"].(string)
+	svc.ID = ServiceID(id)
+	svc.Name, _ = props["Name"].(string)
+	svc.IsManaged, _ = props["IsManaged"].(bool)
+	svc.IsDefaultAUService, _ = props["IsDefaultAUService"].(bool)
+	if svc.Name == "" {
+		svc.Name = Name(svc.ID)
+	}
+	return svc, nil
+}
+
 // RemoveService removes a service registration from Windows Update Agent (WUA).
 func (m *ServiceManager) RemoveService(s ServiceID) error {
-	_, err := oleutil.CallMethod(m.ServiceManager, "RemoveService", string(s))
-	return err
+	if err := cablib.CheckWritable("remove update service"); err != nil {
+		return err
+	}
+	if _, err := oleutil.CallMethod(m.ServiceManager, "RemoveService", string(s)); err != nil {
+		return err
+	}
+	changed()
+	return nil
 }
 
 // Close turns down any open service manager sessions.
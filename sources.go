@@ -0,0 +1,136 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"flag"
+	"github.com/google/cabbie/search"
+	"github.com/google/cabbie/servicemgr"
+	"github.com/google/cabbie/session"
+	"github.com/google/cabbie/updates"
+	"github.com/google/subcommands"
+)
+
+// Available flags
+type sourcesCmd struct {
+}
+
+func (sourcesCmd) Name() string { return "sources" }
+func (sourcesCmd) Synopsis() string {
+	return "List the update services offering each pending update."
+}
+func (sourcesCmd) Usage() string {
+	return fmt.Sprintf("%s sources\n", filepath.Base(os.Args[0]))
+}
+func (c *sourcesCmd) SetFlags(f *flag.FlagSet) {}
+
+func (c sourcesCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	srcs, err := pendingSources(ctx)
+	if err != nil {
+		fmt.Printf("Failed to get update sources: %v\n", err)
+		elog.Error(119, fmt.Sprintf("Failed to get update sources: %v", err))
+		return subcommands.ExitFailure
+	}
+	if len(srcs) == 0 {
+		fmt.Println("No pending updates found.")
+		return subcommands.ExitSuccess
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Sources\tUpdateID\tTitle")
+	for _, s := range srcs {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", strings.Join(s.Services, ","), s.UpdateID, s.Title)
+	}
+	w.Flush()
+	return subcommands.ExitSuccess
+}
+
+// updateSource is a pending update along with the names of the services offering it.
+type updateSource struct {
+	Title, UpdateID string
+	Services        []string
+}
+
+// pendingSources searches each registered update service for pending updates and returns the
+// services offering each update found by Cabbie's configured search. The configured search runs last
+// so the client configuration it applies is left in place. The searches share one session.
+func pendingSources(ctx context.Context) ([]updateSource, error) {
+	m, err := servicemgr.InitMgrService()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create update service manager: %v", err)
+	}
+	svcs, err := m.Services()
+	m.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate update services: %v", err)
+	}
+
+	// Start Windows update session
+	s, err := session.New()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new Windows Update session: %v", err)
+	}
+	defer s.Close()
+
+	offered := make(map[string][]string)
+	for _, svc := range svcs {
+		ups, err := searchPending(ctx, s, &svc)
+		if err != nil {
+			searchLog.Warning(002, fmt.Sprintf("Failed to search update service %s [%s]: %v", svc.Name, svc.ID, err))
+			continue
+		}
+		for _, u := range ups {
+			offered[u.Identity.UpdateID] = append(offered[u.Identity.UpdateID], svc.Name)
+		}
+	}
+
+	ups, err := searchPending(ctx, s, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sourcesFor(ups, offered), nil
+}
+
+func sourcesFor(ups []*updates.Update, offered map[string][]string) []updateSource {
+	var srcs []updateSource
+	for _, u := range ups {
+		s := updateSource{Title: u.Title, UpdateID: u.Identity.UpdateID, Services: offered[u.Identity.UpdateID]}
+		if len(s.Services) == 0 {
+			s.Services = []string{"unknown"}
+		}
+		sort.Strings(s.Services)
+		srcs = append(srcs, s)
+	}
+	sort.SliceStable(srcs, func(i, j int) bool { return srcs[i].Title < srcs[j].Title })
+	return srcs
+}
+
+// searchPending searches svc in the session s for pending updates, or uses Cabbie's configured
+// source when svc is nil.
+func searchPending(ctx context.Context, s *session.UpdateSession, svc *servicemgr.Service) ([]*updates.Update, error) {
+	uc, err := searchUpdates(ctx, search.BasicSearch, searchOptions{session: s, service: svc})
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+	return uc.Updates, nil
+}
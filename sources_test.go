@@ -0,0 +1,36 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"testing"
+
+	"github.com/google/cabbie/updates"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSourcesFor(t *testing.T) {
+	ups := []*updates.Update{
+		{Title: "B", Identity: updates.Identity{UpdateID: "b"}},
+		{Title: "A", Identity: updates.Identity{UpdateID: "a"}},
+	}
+	offered := map[string][]string{"a": {"Windows Update", "Microsoft Update"}}
+	want := []updateSource{
+		{Title: "A", UpdateID: "a", Services: []string{"Microsoft Update", "Windows Update"}},
+		{Title: "B", UpdateID: "b", Services: []string{"unknown"}},
+	}
+	if diff := cmp.Diff(want, sourcesFor(ups, offered)); diff != "" {
+		t.Errorf("sourcesFor() returned diff (-want +got):\n%s", diff)
+	}
+}
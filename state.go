@@ -0,0 +1,204 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// State persists the outcome of update operations between Cabbie runs.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/cabbie/updates"
+)
+
+const stateDir = "C:\\ProgramData\\Cabbie\\state"
+
+var stateFile = filepath.Join(stateDir, "state.json")
+
+// runState is the persisted record of previous update operations.
+type runState struct {
+	// Downloads holds the latest download result for an update keyed by UpdateID.
+	Downloads map[string]*updateResult `json:"downloads"`
+	// Installs holds the latest install result for an update keyed by UpdateID.
+	Installs map[string]*updateResult `json:"installs,omitempty"`
+	// Budget holds the bytes downloaded during the current download budget period.
+	Budget *budgetUsage `json:"download_budget,omitempty"`
+}
+
+// budgetUsage is the number of bytes downloaded during a budget period.
+type budgetUsage struct {
+	Period string `json:"period"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// downloadBudget tracks the bytes downloaded against a configured limit.
+type downloadBudget struct {
+	limit int64
+	usage *budgetUsage
+}
+
+// newDownloadBudget returns a budget of limitMB megabytes. A monthly budget carries the bytes
+// already consumed this calendar month in st over from previous runs; otherwise usage starts at zero
+// for each run. A limit of zero returns a nil budget, which allows every download.
+func newDownloadBudget(st *runState, limitMB uint64, monthly bool, now time.Time) *downloadBudget {
+	if limitMB == 0 {
+		return nil
+	}
+	b := &downloadBudget{limit: int64(limitMB) * 1024 * 1024, usage: &budgetUsage{}}
+	if !monthly {
+		return b
+	}
+	period := now.Format("2006-01")
+	if st.Budget == nil || st.Budget.Period != period {
+		st.Budget = &budgetUsage{Period: period}
+	}
+	b.usage = st.Budget
+	return b
+}
+
+// allows reports whether downloading size more bytes stays within the budget.
+func (b *downloadBudget) allows(size int64) bool {
+	if b == nil {
+		return true
+	}
+	return b.usage.Bytes+size <= b.limit
+}
+
+// consume records size bytes as downloaded.
+func (b *downloadBudget) consume(size int64) {
+	if b == nil {
+		return
+	}
+	b.usage.Bytes += size
+}
+
+func (b *downloadBudget) String() string {
+	return fmt.Sprintf("%d of %d bytes used", b.usage.Bytes, b.limit)
+}
+
+// updateResult is the outcome of a single operation on an update.
+type updateResult struct {
+	UpdateID   string    `json:"update_id"`
+	Title      string    `json:"title"`
+	ResultCode int       `json:"result_code"`
+	Error      string    `json:"error,omitempty"`
+	HResult    string    `json:"hresult,omitempty"`
+	Attempts   int       `json:"attempts,omitempty"`
+	Time       time.Time `json:"time"`
+	Meta       runMeta   `json:"meta,omitempty"`
+}
+
+func (r *updateResult) succeeded() bool {
+	return r.Error == "" && r.ResultCode == 2
+}
+
+// loadState reads the state file at path. A missing file returns an empty state.
+func loadState(path string) (*runState, error) {
+	s := &runState{Downloads: make(map[string]*updateResult), Installs: make(map[string]*updateResult)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("loadState: error reading %q: %v", path, err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return s, fmt.Errorf("loadState: error unmarshalling %q: %v", path, err)
+	}
+	if s.Downloads == nil {
+		s.Downloads = make(map[string]*updateResult)
+	}
+	if s.Installs == nil {
+		s.Installs = make(map[string]*updateResult)
+	}
+	return s, nil
+}
+
+// save writes the state to path, replacing any previous state.
+func (s *runState) save(path string) error {
+	if err := writeJSONFile(path, s); err != nil {
+		return fmt.Errorf("saveState: %v", err)
+	}
+	return nil
+}
+
+// writeJSONFile writes v to path as indented JSON. The file is replaced atomically so readers never
+// see a partial write.
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling %T: %v", v, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0664); err != nil {
+		return fmt.Errorf("error creating %q: %v", filepath.Dir(path), err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0664); err != nil {
+		return fmt.Errorf("error writing %q: %v", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// recordDownload stores the result of downloading an update.
+func (s *runState) recordDownload(u *updates.Update, rc int, err error) *updateResult {
+	r := &updateResult{
+		UpdateID:   u.Identity.UpdateID,
+		Title:      u.Title,
+		ResultCode: rc,
+		Time:       time.Now(),
+		Meta:       meta.clone(),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	s.Downloads[r.UpdateID] = r
+	return r
+}
+
+// recordInstall stores the result of installing an update, including how many attempts were made.
+func (s *runState) recordInstall(u *updates.Update, rsp *installRsp, err error) *updateResult {
+	r := &updateResult{
+		UpdateID: u.Identity.UpdateID,
+		Title:    u.Title,
+		Time:     time.Now(),
+		Meta:     meta.clone(),
+	}
+	if rsp != nil {
+		r.ResultCode = rsp.resultCode
+		r.HResult = rsp.hResult
+		r.Attempts = rsp.attempts
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	s.Installs[r.UpdateID] = r
+	return r
+}
+
+// failedDownloads returns the updates whose latest download did not succeed, ordered by UpdateID.
+func (s *runState) failedDownloads() []*updateResult {
+	var f []*updateResult
+	for _, r := range s.Downloads {
+		if !r.succeeded() {
+			f = append(f, r)
+		}
+	}
+	sort.Slice(f, func(i, j int) bool { return f[i].UpdateID < f[j].UpdateID })
+	return f
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/cabbie/updates"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cabbie")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state", "state.json")
+
+	st, err := loadState(path)
+	if err != nil {
+		t.Fatalf("loadState(%q) on missing file returned error: %v", path, err)
+	}
+	st.recordDownload(&updates.Update{Title: "good", Identity: updates.Identity{UpdateID: "b"}}, 2, nil)
+	st.recordDownload(&updates.Update{Title: "bad", Identity: updates.Identity{UpdateID: "a"}}, 4, nil)
+	st.recordDownload(&updates.Update{Title: "broken", Identity: updates.Identity{UpdateID: "c"}}, 0, errors.New("download error"))
+	if err := st.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, r := range got.failedDownloads() {
+		ids = append(ids, r.UpdateID)
+	}
+	if diff := cmp.Diff([]string{"a", "c"}, ids); diff != "" {
+		t.Errorf("failedDownloads() returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDownloadBudget(t *testing.T) {
+	const mb = 1024 * 1024
+	oct := time.Date(2020, 10, 15, 0, 0, 0, 0, time.UTC)
+
+	if b := newDownloadBudget(&runState{}, 0, true, oct); !b.allows(1 << 40) {
+		t.Error("disabled budget did not allow download")
+	}
+
+	st := &runState{Budget: &budgetUsage{Period: "2020-10", Bytes: 90 * mb}}
+	b := newDownloadBudget(st, 100, true, oct)
+	if !b.allows(10 * mb) {
+		t.Error("allows(10MB) = false with 10MB remaining, want true")
+	}
+	b.consume(10 * mb)
+	if b.allows(1) {
+		t.Error("allows(1) = true with exhausted budget, want false")
+	}
+	if st.Budget.Bytes != 100*mb {
+		t.Errorf("persisted usage = %d, want %d", st.Budget.Bytes, 100*mb)
+	}
+
+	// A new month starts with a fresh budget.
+	b = newDownloadBudget(st, 100, true, oct.AddDate(0, 1, 0))
+	if !b.allows(100 * mb) {
+		t.Error("allows(100MB) = false at start of new month, want true")
+	}
+
+	// Per run budgets ignore previous usage.
+	st = &runState{Budget: &budgetUsage{Period: "2020-10", Bytes: 100 * mb}}
+	if b := newDownloadBudget(st, 100, false, oct); !b.allows(100 * mb) {
+		t.Error("allows(100MB) = false for per run budget, want true")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"flag"
+	"github.com/google/cabbie/cablib"
+	"github.com/google/cabbie/compliance"
+	"github.com/google/cabbie/updates"
+	"github.com/google/subcommands"
+)
+
+// remainingSearch queries for applicable updates that are neither installed nor waiting on a reboot
+// to finish installing.
+const remainingSearch = compliance.PendingSearch + " and RebootRequired=0"
+
+// Exit codes returned by the status command, so orchestration can decide whether to reboot and
+// whether to run Cabbie again afterwards.
+const (
+	statusRebootToComplete subcommands.ExitStatus = 6
+	statusInstallsPending  subcommands.ExitStatus = 7
+)
+
+// Available flags
+type statusCmd struct {
+}
+
+func (statusCmd) Name() string { return "status" }
+func (statusCmd) Synopsis() string {
+	return "Report whether a reboot completes pending installs or further installs are needed."
+}
+func (statusCmd) Usage() string {
+	return fmt.Sprintf("%s status\n", filepath.Base(os.Args[0]))
+}
+func (c *statusCmd) SetFlags(f *flag.FlagSet) {}
+
+func (c statusCmd) Execute(ctx context.Context, flags *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	ind, err := cablib.PendingReboot()
+	if err != nil {
+		fmt.Printf("Failed to read reboot indicators: %v\n", err)
+		elog.Error(118, fmt.Sprintf("Failed to read reboot indicators: %v", err))
+		return subcommands.ExitFailure
+	}
+	remaining, err := remainingUpdates(ctx)
+	if err != nil {
+		fmt.Printf("Failed to search for remaining updates: %v\n", err)
+		elog.Error(118, fmt.Sprintf("Failed to search for remaining updates: %v", err))
+		return subcommands.ExitFailure
+	}
+
+	if build, err := cablib.OSBuild(); err == nil {
+		tested := "tested"
+		if len(cablib.DegradedFeatures(build)) > 0 {
+			tested = "untested"
+		}
+		fmt.Printf("OS build: %d (%s)\n", build, tested)
+	}
+	fmt.Printf("Windows Update reboot required: %t\n", ind.WindowsUpdate)
+	fmt.Printf("Component servicing reboot pending: %t\n", ind.ComponentServicing)
+	fmt.Printf("Automatic Updates reboot required: %t\n", ind.AutoUpdate)
+	fmt.Printf("Pending file rename operations: %t\n", ind.FileRenames)
+	fmt.Printf("Installer busy: %t\n", ind.InstallerBusy)
+	if len(remaining) > 0 {
+		fmt.Printf("Updates remaining to install:\n%s\n", strings.Join(remaining, "\n"))
+	}
+
+	state := ind.State(len(remaining))
+	fmt.Printf("\nStatus: %s\n", state)
+	return statusExitCode(state)
+}
+
+func statusExitCode(s cablib.RebootState) subcommands.ExitStatus {
+	switch s {
+	case cablib.RebootToComplete:
+		return statusRebootToComplete
+	case cablib.InstallsPending:
+		return statusInstallsPending
+	}
+	return subcommands.ExitSuccess
+}
+
+// remainingUpdates returns the titles of updates in the required categories that still need to be
+// installed, excluding those only waiting on a reboot.
+func remainingUpdates(ctx context.Context) ([]string, error) {
+	uc, err := searchUpdates(ctx, remainingSearch, searchOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer uc.Close()
+
+	return remainingTitles(uc.Updates, config.RequiredCategories), nil
+}
+
+func remainingTitles(ups []*updates.Update, rc []string) []string {
+	var titles []string
+	for _, u := range ups {
+		if u.IsInstalled || !u.InCategories(rc) {
+			continue
+		}
+		titles = append(titles, u.Title)
+	}
+	return titles
+}
@@ -0,0 +1,86 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package updatehistory
+
+import (
+	"time"
+
+	"github.com/google/cabbie/updates"
+)
+
+const day = 24 * time.Hour
+
+// Bucket is the number of successful installs and uninstalls recorded during an interval starting
+// at Start.
+type Bucket struct {
+	Start      time.Time
+	Installs   int
+	Uninstalls int
+}
+
+// Activity counts the successful installs and uninstalls in entries over the window ending at end,
+// in buckets of interval aligned to midnight in loc. Whole day intervals follow calendar days, so
+// buckets spanning a daylight saving change stay aligned to midnight. Every bucket in the window is
+// returned, oldest first, including those without activity, so the series is continuous.
+func Activity(entries []*Entry, end time.Time, window, interval time.Duration, loc *time.Location) []Bucket {
+	if interval <= 0 || window <= 0 {
+		return nil
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	n := int((window + interval - 1) / interval)
+	buckets := make([]Bucket, n)
+	for i := range buckets {
+		buckets[i].Start = bucketStart(end, interval, loc, n-1-i)
+	}
+	windowEnd := bucketStart(end, interval, loc, -1)
+
+	for _, e := range entries {
+		if !e.ResultCode.Succeeded() {
+			continue
+		}
+		if e.Date.Before(buckets[0].Start) || !e.Date.Before(windowEnd) {
+			continue
+		}
+		// Buckets are few, so a linear scan from the newest is simplest.
+		i := n - 1
+		for e.Date.Before(buckets[i].Start) {
+			i--
+		}
+		switch e.Operation {
+		case updates.OperationInstallation:
+			buckets[i].Installs++
+		case updates.OperationUninstallation:
+			buckets[i].Uninstalls++
+		}
+	}
+	return buckets
+}
+
+// bucketStart returns the start of the bucket containing t, moved back by the given number of
+// intervals. Negative values move forward.
+func bucketStart(t time.Time, interval time.Duration, loc *time.Location, back int) time.Time {
+	t = t.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	if interval%day == 0 {
+		days := int(interval / day)
+		return midnight.AddDate(0, 0, -back*days)
+	}
+	start := midnight.Add(t.Sub(midnight) / interval * interval)
+	return start.Add(-time.Duration(back) * interval)
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updatehistory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestActivity(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	end := time.Date(2020, 6, 10, 15, 0, 0, 0, loc)
+	at := func(d, h int) time.Time { return time.Date(2020, 6, d, h, 0, 0, 0, loc) }
+	entries := []*Entry{
+		{Operation: 1, ResultCode: 2, Date: at(10, 1)},
+		{Operation: 1, ResultCode: 3, Date: at(10, 9)},
+		{Operation: 2, ResultCode: 2, Date: at(9, 23)},
+		{Operation: 1, ResultCode: 4, Date: at(9, 12)},
+		{Operation: 1, ResultCode: 2, Date: at(7, 12)},
+		{Operation: 1, ResultCode: 2, Date: at(1, 12)},
+	}
+
+	got := Activity(entries, end, 3*day, day, loc)
+	want := []Bucket{
+		{Start: at(8, 0)},
+		{Start: at(9, 0), Uninstalls: 1},
+		{Start: at(10, 0), Installs: 2},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Activity(daily) returned diff (-want +got):\n%s", diff)
+	}
+
+	// The uninstall late on June 9th in UTC-5 falls on June 10th in UTC.
+	utc := func(d int) time.Time { return time.Date(2020, 6, d, 0, 0, 0, 0, time.UTC) }
+	got = Activity(entries, end, 2*day, day, time.UTC)
+	want = []Bucket{
+		{Start: utc(9)},
+		{Start: utc(10), Installs: 2, Uninstalls: 1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Activity(daily, UTC) returned diff (-want +got):\n%s", diff)
+	}
+
+	got = Activity(entries, end, 12*time.Hour, 6*time.Hour, loc)
+	want = []Bucket{
+		{Start: at(10, 6), Installs: 1},
+		{Start: at(10, 12)},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Activity(6h) returned diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package updatehistory
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/google/cabbie/cablib"
+	"github.com/go-ole/go-ole"
+)
+
+// apartment runs functions on a single goroutine locked to an OS thread that has initialized COM.
+// A history acquires its collection and items on its own apartment and releases them there on
+// Close, so every object is released on the thread that acquired it.
+type apartment struct {
+	calls chan func()
+}
+
+// newApartment starts an apartment, which runs until stop is called.
+func newApartment() *apartment {
+	a := &apartment{calls: make(chan func())}
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		if err := cablib.InitializeCOM(); err != nil {
+			log.Warning(2, fmt.Sprintf("Failed to initialize COM for update history: %v", err))
+		} else {
+			defer ole.CoUninitialize()
+		}
+		for f := range a.calls {
+			f()
+		}
+	}()
+	return a
+}
+
+// do runs f on the thread of the apartment and waits for it to return.
+func (a *apartment) do(f func()) {
+	done := make(chan struct{})
+	a.calls <- func() {
+		defer close(done)
+		f()
+	}
+	<-done
+}
+
+// stop ends the apartment once the function it is running returns. It must not be used afterwards.
+func (a *apartment) stop() {
+	close(a.calls)
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package updatehistory
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var csvHeader = []string{"Title", "UpdateID", "Date", "Operation", "ResultCode", "HResult", "UnmappedResultCode", "Categories"}
+
+// WriteCSV writes the entries to w as CSV with a header row, in the order of Entries. Categories are
+// joined by semicolons. Entries without a Date have an empty Date column, and an UnmappedResultCode of
+// zero is left empty.
+func (hc *History) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return fmt.Errorf("error writing CSV header: %v", err)
+	}
+	for _, e := range hc.Entries {
+		var date string
+		if !e.Date.IsZero() {
+			date = e.Date.Format(time.RFC3339)
+		}
+		var unmapped string
+		if e.UnmappedResultCode != 0 {
+			unmapped = fmt.Sprintf("0x%08X", uint32(e.UnmappedResultCode))
+		}
+		cats := make([]string, len(e.Categories))
+		for i, c := range e.Categories {
+			cats[i] = c.Name
+		}
+		row := []string{
+			e.Title,
+			e.UpdateIdentity.UpdateID,
+			date,
+			e.Operation.String(),
+			e.ResultCode.String(),
+			fmt.Sprintf("0x%08X", uint32(e.HResult)),
+			unmapped,
+			strings.Join(cats, ";"),
+		}
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("error writing CSV row for %q: %v", e.Title, err)
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,49 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updatehistory
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/google/cabbie/updates"
+)
+
+func TestWriteCSV(t *testing.T) {
+	h := &History{Entries: []*Entry{
+		{
+			Title:              "2020-06 Update, for Windows (KB4561608)",
+			UpdateIdentity:     updates.Identity{UpdateID: "abc"},
+			Date:               time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC),
+			Operation:          updates.OperationInstallation,
+			ResultCode:         updates.ResultFailed,
+			HResult:            -2145124329,
+			UnmappedResultCode: -2147024784,
+			Categories:         []updates.Category{{Name: "Security Updates"}, {Name: "Windows 10"}},
+		},
+		{Title: "Undated", Operation: updates.OperationUninstallation, ResultCode: updates.ResultSucceeded},
+	}}
+	var b bytes.Buffer
+	if err := h.WriteCSV(&b); err != nil {
+		t.Fatalf("WriteCSV() returned unexpected error: %v", err)
+	}
+	want := "Title,UpdateID,Date,Operation,ResultCode,HResult,UnmappedResultCode,Categories\n" +
+		"\"2020-06 Update, for Windows (KB4561608)\",abc,2020-06-01T12:30:00Z,Installation,Failed,0x80240017,0x80070070,Security Updates;Windows 10\n" +
+		"Undated,,,Uninstallation,Succeeded,0x00000000,,\n"
+	if got := b.String(); got != want {
+		t.Errorf("WriteCSV() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package updatehistory
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors wrapped by the failures to read the history collection, so callers can decide with
+// errors.Is whether to retry.
+var (
+	// ErrHistoryCount wraps failures to count the entries in the history.
+	ErrHistoryCount = errors.New("error counting update history")
+	// ErrQueryHistory wraps failures to query the history collection or its items.
+	ErrQueryHistory = errors.New("error querying update history")
+)
+
+// EnumerationError holds the entries of a history that could not be expanded.
+type EnumerationError struct {
+	Entries []EntryError
+}
+
+func (e *EnumerationError) Error() string {
+	return fmt.Sprintf("errors in update enumeration: %v", e.Entries)
+}
+
+// Unwrap returns the EntryError of each entry, for errors.As and errors.Is.
+func (e *EnumerationError) Unwrap() []error {
+	errs := make([]error, len(e.Entries))
+	for i, ee := range e.Entries {
+		errs[i] = ee
+	}
+	return errs
+}
+
+// Err returns an *EnumerationError holding the entries that could not be expanded, or nil when every
+// entry was.
+func (hc *History) Err() error {
+	if len(hc.Errors) == 0 {
+		return nil
+	}
+	return &EnumerationError{Entries: hc.Errors}
+}
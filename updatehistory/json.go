@@ -0,0 +1,103 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package updatehistory
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/cabbie/cablib"
+	"github.com/google/cabbie/servicemgr"
+)
+
+// jsonEntry is the serialized form of an Entry, without its COM item.
+type jsonEntry struct {
+	Date                string         `json:"date"`
+	Operation           string         `json:"operation"`
+	ResultCode          string         `json:"result_code"`
+	HResult             string         `json:"hresult"`
+	UnmappedResultCode  string         `json:"unmapped_result_code,omitempty"`
+	UpdateID            string         `json:"update_id"`
+	RevisionNumber      int            `json:"revision_number"`
+	Title               string         `json:"title"`
+	Description         string         `json:"description"`
+	ClientApplicationID string         `json:"client_application_id"`
+	ServerSelection     string         `json:"server_selection"`
+	ServiceID           string         `json:"service_id"`
+	ServiceName         string         `json:"service_name"`
+	UninstallationNotes string         `json:"uninstallation_notes"`
+	SupportURL          string         `json:"support_url"`
+	Categories          []jsonCategory `json:"categories"`
+	KBArticleIDs        []string       `json:"kb_article_ids"`
+	Severity            string         `json:"severity"`
+}
+
+type jsonCategory struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	CategoryID string `json:"category_id"`
+}
+
+// MarshalJSON encodes the decoded fields of the entry with lowercase keys, leaving out its COM item.
+// An entry without a Date has an empty date.
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	j := jsonEntry{
+		Operation:           e.Operation.String(),
+		ResultCode:          e.ResultCode.String(),
+		HResult:             cablib.HResultString(e.HResult),
+		UpdateID:            e.UpdateIdentity.UpdateID,
+		RevisionNumber:      e.UpdateIdentity.RevisionNumber,
+		Title:               e.Title,
+		Description:         e.Description,
+		ClientApplicationID: e.ClientApplicationID,
+		ServerSelection:     e.ServerSelection.String(),
+		ServiceID:           e.ServiceID,
+		ServiceName:         servicemgr.Name(servicemgr.ServiceID(e.ServiceID)),
+		UninstallationNotes: e.UninstallationNotes,
+		SupportURL:          e.SupportURL,
+		Categories:          []jsonCategory{},
+		KBArticleIDs:        e.KBArticleIDs,
+		Severity:            e.Severity,
+	}
+	if j.KBArticleIDs == nil {
+		j.KBArticleIDs = []string{}
+	}
+	if e.UnmappedResultCode != 0 {
+		j.UnmappedResultCode = cablib.HResultString(e.UnmappedResultCode)
+	}
+	if !e.Date.IsZero() {
+		j.Date = e.Date.Format(time.RFC3339)
+	}
+	for _, c := range e.Categories {
+		j.Categories = append(j.Categories, jsonCategory{Name: c.Name, Type: c.Type, CategoryID: c.CategoryID})
+	}
+	return json.Marshal(j)
+}
+
+// MarshalJSON encodes the history as the array of its entries, leaving out the COM collection.
+func (hc *History) MarshalJSON() ([]byte, error) {
+	entries := hc.Entries
+	if entries == nil {
+		entries = []*Entry{}
+	}
+	return json.Marshal(entries)
+}
+
+// ToJSON returns the entries of the history as an indented JSON array.
+func (hc *History) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(hc, "", "  ")
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updatehistory
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-ole/go-ole"
+	"github.com/google/cabbie/updates"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestToJSON(t *testing.T) {
+	h := &History{
+		IUpdateHistoryEntryCollection: new(ole.IDispatch),
+		Entries: []*Entry{
+			{
+				Item:               new(ole.IDispatch),
+				Operation:          updates.OperationInstallation,
+				ResultCode:         updates.ResultFailed,
+				HResult:            -2145124321,
+				UnmappedResultCode: -2147024784,
+				Date:               time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC),
+				UpdateIdentity:     updates.Identity{UpdateID: "abc", RevisionNumber: 201},
+				Title:              "Update",
+				ServiceID:          "9482F4B4-E343-43B6-B170-9A65BC822C77",
+				ServerSelection:    updates.ServerSelectionWindowsUpdate,
+				Categories:         []updates.Category{{Name: "Security Updates", Type: "UpdateClassification", CategoryID: "0fa1201d"}},
+			},
+			{Title: "Undated", Operation: 3},
+		},
+	}
+	b, err := h.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON() returned unexpected error: %v", err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("ToJSON() = %s, which is not a JSON array: %v", b, err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("ToJSON() returned %d entries, want 2:\n%s", len(got), b)
+	}
+
+	want := map[string]interface{}{
+		"date":                  "2020-06-01T12:30:00Z",
+		"operation":             "Installation",
+		"result_code":           "Failed",
+		"hresult":               "0x8024001F (WU_E_NO_CONNECTION)",
+		"unmapped_result_code":  "0x80070070",
+		"update_id":             "abc",
+		"revision_number":       float64(201),
+		"title":                 "Update",
+		"description":           "",
+		"client_application_id": "",
+		"server_selection":      "WindowsUpdate",
+		"service_id":            "9482F4B4-E343-43B6-B170-9A65BC822C77",
+		"service_name":          "Windows Update",
+		"uninstallation_notes":  "",
+		"support_url":           "",
+		"kb_article_ids":        []interface{}{},
+		"severity":              "",
+		"categories": []interface{}{
+			map[string]interface{}{"name": "Security Updates", "type": "UpdateClassification", "category_id": "0fa1201d"},
+		},
+	}
+	if diff := cmp.Diff(want, got[0]); diff != "" {
+		t.Errorf("ToJSON() entry returned diff (-want +got):\n%s", diff)
+	}
+	if got[1]["date"] != "" || got[1]["operation"] != "Unknown(3)" {
+		t.Errorf("ToJSON() undated entry = %v, want empty date and Unknown(3) operation", got[1])
+	}
+	if _, ok := got[1]["unmapped_result_code"]; ok {
+		t.Errorf("ToJSON() undated entry = %v, want no unmapped_result_code", got[1])
+	}
+
+	empty, err := (&History{}).ToJSON()
+	if err != nil || string(empty) != "[]" {
+		t.Errorf("ToJSON() of empty history = %s, %v, want []", empty, err)
+	}
+}
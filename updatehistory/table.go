@@ -0,0 +1,124 @@
+// Copyright 2019 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build windows
+
+package updatehistory
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/google/cabbie/updates"
+	"golang.org/x/sys/windows"
+)
+
+// ANSI escape sequences used to color table rows. Every color has the same length so colored rows
+// stay aligned by tabwriter.
+const (
+	colorDefault = "\x1b[39m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorReset   = "\x1b[0m"
+)
+
+var (
+	titleWidthMu sync.Mutex
+	titleWidth   = 60
+)
+
+// SetTitleWidth sets the number of characters of a Title WriteTable shows before truncating it with
+// an ellipsis, which defaults to 60. Zero or less shows the whole Title.
+func SetTitleWidth(n int) {
+	titleWidthMu.Lock()
+	defer titleWidthMu.Unlock()
+	titleWidth = n
+}
+
+// WriteTable writes the entries to w as aligned columns with a header row, in the order of Entries.
+// Dates are shown in local time and entries without a Date or KBs show "-". When color is set, each
+// row is colored by its result: green succeeded, yellow not yet complete and red failed. Use
+// ColorEnabled to decide whether w can display colors.
+func (hc *History) WriteTable(w io.Writer, color bool) error {
+	titleWidthMu.Lock()
+	width := titleWidth
+	titleWidthMu.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(c, cells string) {
+		if color {
+			fmt.Fprintf(tw, "%s%s%s\n", c, cells, colorReset)
+			return
+		}
+		fmt.Fprintln(tw, cells)
+	}
+	row(colorDefault, "Date\tOperation\tResult\tTitle\tKB")
+	for _, e := range hc.Entries {
+		date := "-"
+		if !e.Date.IsZero() {
+			date = e.Date.Local().Format("2006-01-02 15:04")
+		}
+		kb := strings.Join(e.KBArticleIDs, ",")
+		if kb == "" {
+			kb = "-"
+		}
+		row(resultColor(e.ResultCode), fmt.Sprintf("%s\t%s\t%s\t%s\t%s", date, e.Operation, e.ResultCode, truncate(e.Title, width), kb))
+	}
+	return tw.Flush()
+}
+
+// resultColor returns the color of a row for an entry with the result code rc.
+func resultColor(rc updates.OperationResultCode) string {
+	switch rc {
+	case updates.ResultSucceeded:
+		return colorGreen
+	case updates.ResultNotStarted, updates.ResultInProgress, updates.ResultSucceededWithErrors:
+		return colorYellow
+	case updates.ResultFailed, updates.ResultAborted:
+		return colorRed
+	}
+	return colorDefault
+}
+
+// ColorEnabled reports whether f is a console able to display the colors of WriteTable. Colors are
+// disabled with noColor or by setting the NO_COLOR environment variable, and are never used when f is
+// redirected to a file or pipe.
+func ColorEnabled(f *os.File, noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+	return windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
+
+// truncate shortens s to at most n characters, ending in an ellipsis, when it is longer.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if n <= 0 || len(r) <= n {
+		return s
+	}
+	return strings.TrimRight(string(r[:n-1]), " ") + "…"
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updatehistory
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/cabbie/updates"
+)
+
+func TestWriteTable(t *testing.T) {
+	defer SetTitleWidth(60)
+	SetTitleWidth(20)
+	h := &History{Entries: []*Entry{
+		{
+			Title:        "2020-06 Cumulative Update for Windows 10 (KB4561608)",
+			Date:         time.Date(2020, 6, 1, 12, 30, 0, 0, time.Local),
+			Operation:    updates.OperationInstallation,
+			ResultCode:   updates.ResultFailed,
+			KBArticleIDs: []string{"4561608"},
+		},
+		{Title: "Undated", Operation: updates.OperationUninstallation, ResultCode: updates.ResultSucceeded},
+	}}
+	var b bytes.Buffer
+	if err := h.WriteTable(&b, false); err != nil {
+		t.Fatalf("WriteTable() returned unexpected error: %v", err)
+	}
+	want := "Date              Operation       Result     Title                KB\n" +
+		"2020-06-01 12:30  Installation    Failed     2020-06 Cumulative…  4561608\n" +
+		"-                 Uninstallation  Succeeded  Undated              -\n"
+	if got := b.String(); got != want {
+		t.Errorf("WriteTable() = %q, want %q", got, want)
+	}
+
+	var colored bytes.Buffer
+	if err := h.WriteTable(&colored, true); err != nil {
+		t.Fatalf("WriteTable(color=true) returned unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(colored.String(), "\n"), "\n")
+	plain := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	for i, c := range []string{colorDefault, colorRed, colorGreen} {
+		if !strings.HasPrefix(lines[i], c) || !strings.HasSuffix(lines[i], colorReset) {
+			t.Errorf("WriteTable(color=true) line %d = %q, want color %q", i, lines[i], c)
+			continue
+		}
+		// Colors must not change the alignment of the table.
+		if got := strings.TrimSuffix(lines[i][len(c):], colorReset); got != plain[i] {
+			t.Errorf("WriteTable(color=true) line %d = %q, want %q", i, got, plain[i])
+		}
+	}
+}
+
+func TestResultColor(t *testing.T) {
+	for rc, want := range map[updates.OperationResultCode]string{
+		updates.ResultSucceeded:         colorGreen,
+		updates.ResultInProgress:        colorYellow,
+		updates.ResultNotStarted:        colorYellow,
+		updates.ResultFailed:            colorRed,
+		updates.ResultAborted:           colorRed,
+		updates.OperationResultCode(99): colorDefault,
+	} {
+		if got := resultColor(rc); got != want {
+			t.Errorf("resultColor(%d) = %q, want %q", rc, got, want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"much too long", 5, "much…"},
+		{"ünïcödé", 4, "ünï…"},
+		{"unlimited", 0, "unlimited"},
+	} {
+		if got := truncate(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
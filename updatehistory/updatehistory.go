@@ -18,28 +18,131 @@
 package updatehistory
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"net/url"
 	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/cabbie/cablib"
+	"github.com/google/cabbie/errors"
+	"github.com/google/cabbie/logging"
 	"github.com/google/cabbie/search"
+	"github.com/google/cabbie/servicemgr"
 	"github.com/google/cabbie/updates"
 	"github.com/go-ole/go-ole"
 	"github.com/go-ole/go-ole/oleutil"
 )
 
+var (
+	// Now returns the current time for the helpers that work relative to it, and can be replaced to
+	// freeze time in tests.
+	Now = time.Now
+
+	log = logging.For("updatehistory")
+
+	workersMu sync.Mutex
+	workers   = runtime.NumCPU()
+
+	loggerMu sync.Mutex
+	logger   *slog.Logger
+
+	// getPropertyRetry reads the properties of history entries, replaced in tests.
+	getPropertyRetry = cablib.GetPropertyRetry
+	getString        = propertyString
+
+	// categoryCache holds each category seen by its upper case CategoryID, as the Name and Type of a
+	// category are the same in every entry.
+	categoryCache = struct {
+		sync.Mutex
+		m map[string]updates.Category
+	}{m: make(map[string]updates.Category)}
+)
+
+// SetLogger sets a logger receiving a structured warning for each property of an entry that fails
+// to decode. A nil logger, the default, disables these records.
+func SetLogger(l *slog.Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	logger = l
+}
+
+// fieldError is the error decoding a single property of an entry.
+type fieldError struct {
+	property string
+	err      error
+}
+
+// logFieldErrors records failed properties of the entry titled title with the logger set by
+// SetLogger. The title is empty when it could not be read.
+func logFieldErrors(title string, failed []fieldError) {
+	loggerMu.Lock()
+	l := logger
+	loggerMu.Unlock()
+	if l == nil {
+		return
+	}
+	for _, f := range failed {
+		l.Warn("failed to decode history entry property", "property", f.property, "title", title, "error", f.err)
+	}
+}
+
+// SetWorkers sets how many history entries Get expands in parallel, which defaults to the number of
+// CPUs. Values below one are treated as one.
+func SetWorkers(n int) {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	workers = n
+}
+
+func poolSize() int {
+	workersMu.Lock()
+	defer workersMu.Unlock()
+	return workers
+}
+
 // History represents an ordered read-only list of IUpdateHistoryEntry interfaces.
 type History struct {
 	IUpdateHistoryEntryCollection *ole.IDispatch
 	Entries                       []*Entry
+	// Errors lists the entries that could not be expanded and were left out of Entries.
+	Errors []EntryError
+
+	// apartment is the thread the collection and items were acquired on, and are released on.
+	apartment *apartment
+	closeOnce sync.Once
+	closed    <-chan struct{}
+}
+
+// EntryError holds the errors expanding the history entry at Index of the collection.
+type EntryError struct {
+	Index  int
+	Errors []error
+}
+
+func (e EntryError) Error() string {
+	return fmt.Sprintf("errors expanding history entry %d: %v", e.Index, e.Errors)
+}
+
+// Unwrap returns the errors expanding the entry.
+func (e EntryError) Unwrap() []error {
+	return e.Errors
 }
 
 // Entry represents the recorded history of an update.
 type Entry struct {
 	Item                *ole.IDispatch
-	Operation           int
-	ResultCode          int
+	Operation           updates.Operation
+	ResultCode          updates.OperationResultCode
 	HResult             int
 	Date                time.Time
 	UpdateIdentity      updates.Identity
@@ -47,29 +150,115 @@ type Entry struct {
 	Description         string
 	UnmappedResultCode  int
 	ClientApplicationID string
-	ServerSelection     int
+	ServerSelection     updates.ServerSelection
 	ServiceID           string
 	UninstallationNotes string
 	SupportURL          string
 	Categories          []updates.Category
+	// KBArticleIDs are parsed from the Title, as history entries do not record them. They have no
+	// "KB" prefix, matching IUpdate.KBArticleIDs.
+	KBArticleIDs []string
+	// Severity is the MSRC severity of a security update, such as "Critical", or empty when it is
+	// unknown. History entries do not record it, so it is parsed from the Title.
+	Severity string
+	// Hidden reports whether the update is currently hidden. It is only set by LoadHidden.
+	Hidden bool
 }
 
-// New expands an IUpdateHistoryEntry object into a usable go struct
+// New expands an IUpdateHistoryEntry object into a usable go struct. The errors are those of
+// NewFields in the order of the Entry fields.
 func New(item *ole.IDispatch) (*Entry, []error) {
+	e, fields := NewFields(item)
+	return e, orderedErrors(fields)
+}
+
+// orderedErrors returns the errors keyed by Entry field name in the order of the fields.
+func orderedErrors(fields map[string]error) []error {
+	if len(fields) == 0 {
+		return nil
+	}
 	var errors []error
+	t := reflect.TypeOf(Entry{})
+	for i := 0; i < t.NumField(); i++ {
+		if err, ok := fields[t.Field(i).Name]; ok {
+			errors = append(errors, err)
+		}
+	}
+	return errors
+}
+
+// NewFields is like New but returns the error reading each property keyed by the name of the Entry
+// field it was read into, so a caller can tolerate some fields failing and not others. The map is
+// nil when every field was read.
+func NewFields(item *ole.IDispatch) (*Entry, map[string]error) {
+	return newEntry(item, nil)
+}
+
+// MinimalFields are the Entry fields most history reports need.
+var MinimalFields = []string{"Title", "Date", "UpdateIdentity", "ResultCode"}
+
+// NewWithFields is like New but only reads the named Entry fields from COM, leaving the others at
+// their zero value, which saves the property round trips of the rest. KBArticleIDs is derived from
+// the Title when it is read.
+func NewWithFields(item *ole.IDispatch, fields []string) (*Entry, []error) {
+	want, err := fieldSet(fields)
+	if err != nil {
+		return nil, []error{err}
+	}
+	e, errs := newEntry(item, want)
+	return e, orderedErrors(errs)
+}
+
+// fieldSet returns the named fields of Entry as a set, or an error for a name that is not a field
+// read from COM.
+func fieldSet(fields []string) (map[string]bool, error) {
+	want := make(map[string]bool)
+	t := reflect.TypeOf(Entry{})
+	for _, f := range fields {
+		if _, ok := t.FieldByName(f); !ok || f == "Item" || f == "KBArticleIDs" || f == "Hidden" {
+			return nil, fmt.Errorf("unknown history entry field %q", f)
+		}
+		want[f] = true
+	}
+	return want, nil
+}
+
+// newEntry expands the fields of item in want, or every field when want is nil.
+func newEntry(item *ole.IDispatch, want map[string]bool) (*Entry, map[string]error) {
+	var errs map[string]error
+	var failed []fieldError
 	e := &Entry{Item: item}
 
 	fields := reflect.TypeOf(*e)
 	data := make(map[string]interface{})
-	var err error
 	for i := 0; i < fields.NumField(); i++ {
 		field := fields.Field(i)
 		p := field.Name
+		if p == "Severity" {
+			// History entries have no such property; severity parses it from the Title.
+			continue
+		}
+		if want != nil && !want[p] {
+			continue
+		}
+		var err error
 		switch field.Type.String() {
 		case "string":
 			data[p], err = e.toString(p)
 		case "int":
 			data[p], err = e.toInt(p)
+		case "updates.Operation":
+			var o int
+			o, err = e.toInt(p)
+			data[p] = updates.Operation(o)
+		case "updates.ServerSelection":
+			var s int
+			s, err = e.toInt(p)
+			data[p] = updates.ServerSelection(s)
+		case "updates.OperationResultCode":
+			var rc int
+			rc, err = e.toInt(p)
+			data[p] = updates.OperationResultCode(rc)
 		case "time.Time":
 			data[p], err = e.toDateTime(p)
 		case "[]updates.Category":
@@ -78,19 +267,178 @@ func New(item *ole.IDispatch) (*Entry, []error) {
 			data[p], err = e.toIdentity(p)
 		}
 		if err != nil {
-			errors = append(errors, err)
+			failed = append(failed, fieldError{property: p, err: err})
+		}
+	}
+	if len(failed) > 0 {
+		title, _ := data["Title"].(string)
+		logFieldErrors(title, failed)
+	}
+
+	for k, v := range data {
+		if err := cablib.SetField(e, k, v); err != nil {
+			failed = append(failed, fieldError{property: k, err: err})
+		}
+	}
+	for _, f := range failed {
+		if errs == nil {
+			errs = make(map[string]error)
+		}
+		if _, ok := errs[f.property]; !ok {
+			errs[f.property] = f.err
 		}
 	}
+	e.normalize()
+	e.KBArticleIDs = kbArticleIDs(e.Title)
+	if want == nil || want["Severity"] {
+		e.Severity = e.severity()
+	}
+
+	return e, errs
+}
+
+// severities are the MSRC severity ratings, by their lowercase form.
+var severities = map[string]string{
+	"critical":  "Critical",
+	"important": "Important",
+	"moderate":  "Moderate",
+	"low":       "Low",
+}
+
+var severityRegex = regexp.MustCompile(`(?i)\b(critical|important|moderate|low)\b`)
 
-	if err := e.fillStruct(data); err != nil {
-		errors = append(errors, err)
+// severity returns the MSRC severity of a security update parsed from its Title. IUpdateHistoryEntry
+// has no MsrcSeverity property, unlike the IUpdate returned by a search.
+func (e *Entry) severity() string {
+	if !e.InCategory(updates.CategorySecurityUpdates) {
+		return ""
 	}
+	return titleSeverity(e.Title)
+}
+
+func titleSeverity(title string) string {
+	m := severityRegex.FindStringSubmatch(title)
+	if m == nil {
+		return ""
+	}
+	return severities[strings.ToLower(m[1])]
+}
 
-	return e, errors
+// normalize trims the text fields of the entry, converts the line endings of UninstallationNotes to
+// "\n" and blanks a SupportURL that is not an absolute http or https URL.
+func (e *Entry) normalize() {
+	e.Title = strings.TrimSpace(e.Title)
+	e.Description = strings.TrimSpace(e.Description)
+	e.UninstallationNotes = strings.TrimSpace(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(e.UninstallationNotes))
+	e.SupportURL = strings.TrimSpace(e.SupportURL)
+	if u, err := url.Parse(e.SupportURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e.SupportURL = ""
+	}
+}
+
+// InCategory reports whether the entry is in the category with the GUID categoryID, such as
+// updates.CategorySecurityUpdates. GUIDs are matched case-insensitively.
+func (e *Entry) InCategory(categoryID string) bool {
+	for _, c := range e.Categories {
+		if strings.EqualFold(c.CategoryID, categoryID) {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryCategories are the classifications PrimaryCategory prefers, most preferred first.
+var primaryCategories = []string{
+	updates.CategoryDrivers,
+	updates.CategorySecurityUpdates,
+	updates.CategoryCriticalUpdates,
+}
+
+// PrimaryCategory returns the most specific category of the entry: the Drivers, Security Updates or
+// Critical Updates classification in that order, then any other classification, then the first of
+// its categories. It returns false for an entry without categories.
+func (e *Entry) PrimaryCategory() (updates.Category, bool) {
+	if len(e.Categories) == 0 {
+		return updates.Category{}, false
+	}
+	for _, id := range primaryCategories {
+		for _, c := range e.Categories {
+			if strings.EqualFold(c.CategoryID, id) {
+				return c, true
+			}
+		}
+	}
+	for _, c := range e.Categories {
+		if c.Type == "UpdateClassification" {
+			return c, true
+		}
+	}
+	return e.Categories[0], true
+}
+
+// IsDriver reports whether the entry is a driver update. Categories without the Drivers GUID still
+// count when they are the "Drivers" update classification.
+func (e *Entry) IsDriver() bool {
+	if e.InCategory(updates.CategoryDrivers) {
+		return true
+	}
+	for _, c := range e.Categories {
+		if c.Type == "UpdateClassification" && strings.EqualFold(c.Name, "Drivers") {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Entry) failed() bool {
+	return e.ResultCode == updates.ResultFailed || e.ResultCode == updates.ResultAborted
+}
+
+// HasSupportURL reports whether the entry has a valid SupportURL.
+func (e *Entry) HasSupportURL() bool {
+	return e.SupportURL != ""
+}
+
+var kbRegex = regexp.MustCompile(`(?i)\bKB(\d+)`)
+
+// kbArticleIDs returns the distinct KB numbers referenced in title in the order they appear.
+func kbArticleIDs(title string) []string {
+	var kbs []string
+	seen := make(map[string]bool)
+	for _, m := range kbRegex.FindAllStringSubmatch(title, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			kbs = append(kbs, m[1])
+		}
+	}
+	return kbs
+}
+
+// matcher reports whether an entry should be expanded, reading only the properties it needs from
+// the Item of e.
+type matcher func(e *Entry) (bool, error)
+
+// newMatching expands item unless match rejects it, in which case a nil entry is returned. A nil
+// match expands every item.
+func newMatching(item *ole.IDispatch, match matcher, want map[string]bool) (*Entry, []error) {
+	if match != nil {
+		ok, err := match(&Entry{Item: item})
+		if err != nil {
+			return nil, []error{err}
+		}
+		if !ok {
+			return nil, nil
+		}
+	}
+	if want == nil {
+		return New(item)
+	}
+	e, errs := newEntry(item, want)
+	return e, orderedErrors(errs)
 }
 
 func (e *Entry) toString(property string) (string, error) {
-	p, err := oleutil.GetProperty(e.Item, property)
+	p, err := getPropertyRetry(e.Item, property)
 	if err != nil {
 		return "", err
 	}
@@ -98,19 +446,41 @@ func (e *Entry) toString(property string) (string, error) {
 }
 
 func (e *Entry) toInt(property string) (int, error) {
-	p, err := oleutil.GetProperty(e.Item, property)
+	p, err := getPropertyRetry(e.Item, property)
 	if err != nil {
 		return 0, err
 	}
+	return variantInt(p)
+}
 
-	if p.Value() == nil {
+// variantInt returns the integer held by v, or zero when it is empty. Val is not read directly as
+// it need not hold the value for every VARIANT type.
+func variantInt(v *ole.VARIANT) (int, error) {
+	switch i := v.Value().(type) {
+	case nil:
 		return 0, nil
+	case int8:
+		return int(i), nil
+	case int16:
+		return int(i), nil
+	case int32:
+		return int(i), nil
+	case int64:
+		return int(i), nil
+	case uint8:
+		return int(i), nil
+	case uint16:
+		return int(i), nil
+	case uint32:
+		return int(i), nil
+	case int:
+		return i, nil
 	}
-	return int(p.Value().(int32)), nil
+	return 0, fmt.Errorf("VARIANT of type %d does not hold an integer", v.VT)
 }
 
 func (e *Entry) toDateTime(property string) (time.Time, error) {
-	p, err := oleutil.GetProperty(e.Item, property)
+	p, err := getPropertyRetry(e.Item, property)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -118,40 +488,68 @@ func (e *Entry) toDateTime(property string) (time.Time, error) {
 	if p.Value() == nil {
 		return time.Time{}, nil
 	}
-	return p.Value().(time.Time), nil
+	return utcDate(p.Value().(time.Time)), nil
+}
+
+// utcDate returns the instant of a history date. The WUA records history dates in UTC, but a
+// VARIANT date has no time zone, so the clock reading go-ole returns is read as UTC whatever location
+// it carries.
+func utcDate(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
 }
 
 func (e *Entry) toIdentity(property string) (updates.Identity, error) {
-	i := updates.Identity{}
-	p, err := oleutil.GetProperty(e.Item, property)
+	p, err := getPropertyRetry(e.Item, property)
 	if err != nil {
 		return updates.Identity{}, err
 	}
+	// Some service initiated operations record an entry without an identity.
 	pd := p.ToIDispatch()
+	if pd == nil {
+		return updates.Identity{}, nil
+	}
 	defer pd.Release()
+	return identityOf(pd)
+}
 
-	rn, err := oleutil.GetProperty(pd, "RevisionNumber")
+// identityOf reads an IUpdateIdentity, leaving nil properties at their zero value.
+func identityOf(pd *ole.IDispatch) (updates.Identity, error) {
+	rn, err := getPropertyRetry(pd, "RevisionNumber")
 	if err != nil {
 		return updates.Identity{}, err
 	}
-	i.RevisionNumber = int(rn.Value().(int32))
+	defer rn.Clear()
+	rev, err := variantInt(rn)
+	if err != nil {
+		return updates.Identity{}, fmt.Errorf("RevisionNumber: %v", err)
+	}
 
-	uid, err := oleutil.GetProperty(pd, "UpdateID")
+	uid, err := getPropertyRetry(pd, "UpdateID")
 	if err != nil {
 		return updates.Identity{}, err
 	}
-	i.UpdateID = uid.ToString()
+	defer uid.Clear()
+	i := updates.Identity{RevisionNumber: rev}
+	if uid.Value() != nil {
+		i.UpdateID = uid.ToString()
+	}
 
 	return i, nil
 }
 
 func (e *Entry) toCategories(property string) ([]updates.Category, error) {
 	cs := []updates.Category{}
-	cats, err := oleutil.GetProperty(e.Item, "Categories")
+	cats, err := getPropertyRetry(e.Item, property)
 	if err != nil {
 		return cs, err
 	}
 	catsd := cats.ToIDispatch()
+	if catsd == nil {
+		return cs, nil
+	}
 	defer catsd.Release()
 
 	count, err := cablib.Count(catsd)
@@ -160,121 +558,895 @@ func (e *Entry) toCategories(property string) ([]updates.Category, error) {
 	}
 
 	for i := 0; i < count; i++ {
-		item, err := oleutil.GetProperty(catsd, "item", i)
+		item, err := getPropertyRetry(catsd, "item", i)
 		if err != nil {
 			continue
 		}
 		itemd := item.ToIDispatch()
-
-		n, err := oleutil.GetProperty(itemd, "Name")
-		if err != nil {
-			itemd.Release()
-			continue
-		}
-		t, err := oleutil.GetProperty(itemd, "Type")
-		if err != nil {
-			n.Clear()
-			itemd.Release()
-			continue
-		}
-		c, err := oleutil.GetProperty(itemd, "CategoryID")
+		c, err := category(itemd)
+		itemd.Release()
 		if err != nil {
-			n.Clear()
-			t.Clear()
-			itemd.Release()
 			continue
 		}
-
-		cs = append(cs, updates.Category{
-			Name:       n.ToString(),
-			Type:       t.ToString(),
-			CategoryID: c.ToString()})
-		itemd.Release()
-		n.Clear()
-		t.Clear()
-		c.Clear()
+		cs = append(cs, c)
 	}
 
 	return cs, nil
 }
 
-func (e *Entry) fillStruct(m map[string]interface{}) error {
-	for k, v := range m {
-		if err := cablib.SetField(e, k, v); err != nil {
-			return err
-		}
+// category reads an ICategory. Only the CategoryID is read for a category already in the cache.
+func category(d *ole.IDispatch) (updates.Category, error) {
+	id, err := getString(d, "CategoryID")
+	if err != nil {
+		return updates.Category{}, err
 	}
-	return nil
+	key := strings.ToUpper(id)
+	categoryCache.Lock()
+	c, ok := categoryCache.m[key]
+	categoryCache.Unlock()
+	if ok {
+		c.CategoryID = id
+		return c, nil
+	}
+
+	n, err := getString(d, "Name")
+	if err != nil {
+		return updates.Category{}, err
+	}
+	t, err := getString(d, "Type")
+	if err != nil {
+		return updates.Category{}, err
+	}
+	c = updates.Category{Name: n, Type: t, CategoryID: id}
+	categoryCache.Lock()
+	categoryCache.m[key] = c
+	categoryCache.Unlock()
+	return c, nil
+}
+
+func propertyString(d *ole.IDispatch, property string) (string, error) {
+	p, err := getPropertyRetry(d, property)
+	if err != nil {
+		return "", err
+	}
+	defer p.Clear()
+	return p.ToString(), nil
 }
 
 func (e *Entry) String() string {
-	return fmt.Sprintf("Title: %s\n"+
+	s := fmt.Sprintf("Title: %s\n"+
+		"Operation: %s\n"+
+		"ResultCode: %s\n"+
+		"HResult: %s\n"+
 		"UpdateIdentity: %+v\n"+
+		"ServerSelection: %s\n"+
+		"Service: %s\n"+
 		"ClientApplicationID: %s\n"+
-		"SupportURL: %s\n"+
-		"Categories: %+v", e.Title, e.UpdateIdentity, e.ClientApplicationID, e.SupportURL, e.Categories)
+		"Categories: %+v", e.Title, e.Operation, e.ResultCode, cablib.HResultString(e.HResult), e.UpdateIdentity, e.ServerSelection, servicemgr.Name(servicemgr.ServiceID(e.ServiceID)), e.ClientApplicationID, e.Categories)
+	// The unmapped code often tells apart failures sharing a generic HResult.
+	if e.failed() && e.UnmappedResultCode != 0 {
+		s += fmt.Sprintf("\nUnmappedResultCode: %s", cablib.HResultString(e.UnmappedResultCode))
+	}
+	if e.HasSupportURL() {
+		s += fmt.Sprintf("\nSupportURL: %s", e.SupportURL)
+	}
+	return s
+}
+
+// Filter selects history entries by the Date they were recorded. A zero Since or Until leaves that
+// end of the range open.
+type Filter struct {
+	// Since is the earliest Date included.
+	Since time.Time
+	// Until is the Date at which entries stop being included.
+	Until time.Time
+	// IncludeUndated includes entries without a Date, which are otherwise excluded.
+	IncludeUndated bool
+}
+
+// Contains reports whether an entry recorded at d passes the filter.
+func (f Filter) Contains(d time.Time) bool {
+	if d.IsZero() {
+		return f.IncludeUndated
+	}
+	if !f.Since.IsZero() && d.Before(f.Since) {
+		return false
+	}
+	return f.Until.IsZero() || d.Before(f.Until)
+}
+
+// Last returns a filter for the entries recorded within d of Now.
+func Last(d time.Duration) Filter {
+	return Filter{Since: Now().Add(-d)}
 }
 
-// Get returns a history object containing the list of update history entries.
+func (f Filter) match(e *Entry) (bool, error) {
+	d, err := e.toDateTime("Date")
+	return f.Contains(d), err
+}
+
+// Get returns a history object containing the list of update history entries. Entries that cannot be
+// expanded are recorded in Errors instead of failing the whole history.
 func Get(searchInterface *search.Searcher) (*History, error) {
-	c, err := searchInterface.GetTotalHistoryCount()
+	return GetContext(context.Background(), searchInterface)
+}
+
+// GetContext is like Get but stops expanding entries once ctx is done, releasing the entries
+// acquired so far and returning the error of ctx.
+func GetContext(ctx context.Context, searchInterface *search.Searcher) (*History, error) {
+	return get(ctx, searchInterface, nil, 0)
+}
+
+// GetFiltered returns a history object containing the update history entries passing f. The Date of
+// each entry is read first so entries outside the range are never fully expanded.
+func GetFiltered(searchInterface *search.Searcher, f Filter) (*History, error) {
+	return GetFilteredContext(context.Background(), searchInterface, f)
+}
+
+// GetFilteredContext is like GetFiltered but stops expanding entries once ctx is done.
+func GetFilteredContext(ctx context.Context, searchInterface *search.Searcher, f Filter) (*History, error) {
+	return get(ctx, searchInterface, f.match, 0)
+}
+
+// GetByUpdateID returns every history entry of the update with the GUID updateID, which is matched
+// case-insensitively. Other entries are released without being expanded. The returned entries hold
+// no COM items, so there is nothing to close.
+func GetByUpdateID(searchInterface *search.Searcher, updateID string) ([]*Entry, error) {
+	h, err := get(context.Background(), searchInterface, func(e *Entry) (bool, error) {
+		id, err := e.toIdentity("UpdateIdentity")
+		return strings.EqualFold(id.UpdateID, updateID), err
+	}, 0)
 	if err != nil {
 		return nil, err
 	}
+	h.Close()
+	return h.Entries, nil
+}
 
-	hc, err := searchInterface.QueryHistory(c)
+// GetByService returns a history object containing the entries recorded for the update service with
+// the GUID serviceID, such as servicemgr.WindowsUpdate, which is matched case-insensitively. Entries
+// from other services are released without being expanded.
+func GetByService(searchInterface *search.Searcher, serviceID string) (*History, error) {
+	return get(context.Background(), searchInterface, func(e *Entry) (bool, error) {
+		id, err := e.toString("ServiceID")
+		return strings.EqualFold(strings.TrimSpace(id), strings.TrimSpace(serviceID)), err
+	}, 0)
+}
+
+// GetFields is like Get but only reads the named Entry fields of each entry, such as MinimalFields,
+// as NewWithFields does. This cuts the COM traffic of reports on large histories.
+func GetFields(searchInterface *search.Searcher, fields []string) (*History, error) {
+	want, err := fieldSet(fields)
+	if err != nil {
+		return nil, err
+	}
+	return getFields(context.Background(), searchInterface, nil, 0, want)
+}
+
+// GetRecent returns a history object containing the n most recent update history entries. Only those
+// entries are queried, so it is much cheaper than Get on devices with a long history. An n larger
+// than the history returns every entry.
+func GetRecent(searchInterface *search.Searcher, n int) (*History, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid number of recent history entries %d: must be positive", n)
+	}
+	return get(context.Background(), searchInterface, nil, n)
+}
+
+// CountByResult returns the number of history entries recorded since since with each result code,
+// reading only the Date and ResultCode of each entry. A zero since counts every entry. Entries whose
+// properties cannot be read are not counted.
+func CountByResult(searchInterface *search.Searcher, since time.Time) (map[updates.OperationResultCode]int, error) {
+	c := &resultCounter{since: since, counts: make(map[updates.OperationResultCode]int)}
+	h, err := get(context.Background(), searchInterface, c.match, 0)
 	if err != nil {
 		return nil, err
 	}
+	h.Close()
+	return c.counts, nil
+}
+
+// resultCounter tallies the result codes of entries as they are matched, never expanding them.
+type resultCounter struct {
+	since  time.Time
+	mu     sync.Mutex
+	counts map[updates.OperationResultCode]int
+}
+
+func (c *resultCounter) match(e *Entry) (bool, error) {
+	d, err := e.toDateTime("Date")
+	if err != nil {
+		return false, err
+	}
+	rc, err := e.toInt("ResultCode")
+	if err != nil {
+		return false, err
+	}
+	c.add(d, updates.OperationResultCode(rc))
+	return false, nil
+}
+
+func (c *resultCounter) add(d time.Time, rc updates.OperationResultCode) {
+	if !c.since.IsZero() && d.Before(c.since) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[rc]++
+}
+
+// Walk expands the history entries one at a time, calling fn with each and releasing it before the
+// next is expanded, so at most one entry is held in memory. Entries that cannot be expanded are
+// skipped. Walk stops at the first error returned by fn and returns it.
+func Walk(searchInterface *search.Searcher, fn func(*Entry) error) error {
+	h := &History{apartment: newApartment()}
+	defer h.Close()
+
+	// Walk is sequential, so every item is acquired, expanded and released on the apartment.
+	var err error
+	h.apartment.do(func() {
+		var total int
+		total, err = searchInterface.GetTotalHistoryCount()
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrHistoryCount, err)
+			return
+		}
+		h.IUpdateHistoryEntryCollection, err = searchInterface.QueryHistory(total)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrQueryHistory, err)
+			return
+		}
+		var count int
+		if count, err = h.Count(); err != nil {
+			return
+		}
+		err = walk(count, func(i int) (*ole.IDispatch, error) {
+			item, err := oleutil.GetProperty(h.IUpdateHistoryEntryCollection, "item", i)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrQueryHistory, err)
+			}
+			return item.ToIDispatch(), nil
+		}, New, fn)
+	})
+	return err
+}
+
+func walk(count int, itemAt func(int) (*ole.IDispatch, error), expand func(*ole.IDispatch) (*Entry, []error), fn func(*Entry) error) error {
+	for i := 0; i < count; i++ {
+		item, err := itemAt(i)
+		if err != nil {
+			return err
+		}
+		e, errs := expand(item)
+		if errs != nil {
+			log.Warning(2, fmt.Sprintf("Skipping history entry %d of %d: %v", i+1, count, errs))
+			releaseDispatch(item)
+			continue
+		}
+		err = fn(e)
+		releaseDispatch(item)
+		e.Item = nil
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryCount returns how many of total history entries to query when limited to limit, where 0 is
+// unlimited.
+func queryCount(total, limit int) int {
+	if limit > 0 && limit < total {
+		return limit
+	}
+	return total
+}
+
+// get expands the history entries accepted by match from the most recent limit entries, or from
+// every entry when limit is 0.
+func get(ctx context.Context, searchInterface *search.Searcher, match matcher, limit int) (*History, error) {
+	return getFields(ctx, searchInterface, match, limit, nil)
+}
 
-	h := History{IUpdateHistoryEntryCollection: hc}
+// getFields is like get but only expands the fields in want, or every field when want is nil.
+func getFields(ctx context.Context, searchInterface *search.Searcher, match matcher, limit int, want map[string]bool) (*History, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h := &History{apartment: newApartment()}
+	var items []*ole.IDispatch
+	var c int
+	var err error
+	h.apartment.do(func() { c, items, err = h.query(ctx, searchInterface, limit) })
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
 
-	count, err := h.Count()
+	n := poolSize()
+	log.Debug(2, fmt.Sprintf("Expanding %d of %d history entries with %d workers", len(items), c, n))
+	entries, errs, err := expand(ctx, items, n, func(item *ole.IDispatch) (*Entry, []error) {
+		return newMatching(item, match, want)
+	})
 	if err != nil {
+		log.Debug(2, fmt.Sprintf("Stopped expanding history entries: %v", err))
+		h.apartment.do(func() { release(items) })
 		h.Close()
 		return nil, err
 	}
+	var dropped []*ole.IDispatch
+	for i, uh := range entries {
+		if errs[i] != nil {
+			log.Warning(2, fmt.Sprintf("Skipping history entry %d of %d: %v", i+1, len(items), errs[i]))
+			h.Errors = append(h.Errors, EntryError{Index: i, Errors: errs[i]})
+			dropped = append(dropped, items[i])
+			continue
+		}
+		if uh == nil {
+			dropped = append(dropped, items[i])
+			continue
+		}
+		log.Debug(2, fmt.Sprintf("History entry %d: %q operation %s result %s", i+1, uh.Title, uh.Operation, uh.ResultCode))
+		h.Entries = append(h.Entries, uh)
+	}
+	h.apartment.do(func() { release(dropped) })
+	if match != nil {
+		log.Debug(2, fmt.Sprintf("Kept %d of %d matching history entries", len(h.Entries), len(items)))
+	}
+
+	return h, nil
+}
+
+// query queries the most recent limit history entries, or every entry when limit is 0, and returns
+// how many were queried along with the item of each. It runs on the apartment of the history so the
+// collection and items are acquired on the thread that releases them. On error, the items acquired
+// so far are released and the collection is left for Close.
+func (hc *History) query(ctx context.Context, searchInterface *search.Searcher, limit int) (int, []*ole.IDispatch, error) {
+	total, err := searchInterface.GetTotalHistoryCount()
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", ErrHistoryCount, err)
+	}
+	c := queryCount(total, limit)
+
+	hc.IUpdateHistoryEntryCollection, err = searchInterface.QueryHistory(c)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", ErrQueryHistory, err)
+	}
+	count, err := hc.Count()
+	if err != nil {
+		return 0, nil, err
+	}
 
-	h.Entries = make([]*Entry, count)
+	items := make([]*ole.IDispatch, count)
 	for i := 0; i < count; i++ {
-		item, err := oleutil.GetProperty(h.IUpdateHistoryEntryCollection, "item", i)
+		if err := ctx.Err(); err != nil {
+			release(items)
+			return 0, nil, err
+		}
+		item, err := oleutil.GetProperty(hc.IUpdateHistoryEntryCollection, "item", i)
 		if err != nil {
-			h.Close()
-			return nil, err
+			release(items)
+			return 0, nil, fmt.Errorf("%w: %w", ErrQueryHistory, err)
 		}
-		itemd := item.ToIDispatch()
+		items[i] = item.ToIDispatch()
+	}
+	return c, items, nil
+}
 
-		uh, errors := New(itemd)
-		if errors != nil {
-			itemd.Release()
-			h.Close()
-			return nil, fmt.Errorf("errors in update enumeration: %v", errors)
+// expand converts items into entries with fn using n goroutines. The entry and errors for an item
+// share its index, so entries keep the order of the collection. No further items are expanded once
+// ctx is done, in which case the error of ctx is returned.
+func expand(ctx context.Context, items []*ole.IDispatch, n int, fn func(*ole.IDispatch) (*Entry, []error)) ([]*Entry, [][]error, error) {
+	entries := make([]*Entry, len(items))
+	errs := make([][]error, len(items))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// COM is initialized per thread, so keep each worker on its own.
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			if err := cablib.InitializeCOM(); err != nil {
+				for i := range next {
+					errs[i] = []error{err}
+				}
+				return
+			}
+			defer ole.CoUninitialize()
+			for i := range next {
+				entries[i], errs[i] = fn(items[i])
+			}
+		}()
+	}
+	var err error
+dispatch:
+	for i := range items {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break dispatch
+		case next <- i:
 		}
-		h.Entries[i] = uh
 	}
+	close(next)
+	wg.Wait()
+	return entries, errs, err
+}
 
-	return &h, nil
+// release releases items, skipping those never acquired.
+func release(items []*ole.IDispatch) {
+	for _, i := range items {
+		if i != nil {
+			releaseDispatch(i)
+		}
+	}
 }
 
-// Count gets the number of updates in an IUpdateHistoryEntryCollection.
+// Count gets the number of updates in an IUpdateHistoryEntryCollection. This is the number queried,
+// which can be fewer than search.Searcher.GetTotalHistoryCount reports for the whole history.
 func (hc *History) Count() (int, error) {
 	count, err := oleutil.GetProperty(hc.IUpdateHistoryEntryCollection, "Count")
 	if err != nil {
-		return 0, fmt.Errorf("error getting history collection count, %v", err)
+		return 0, fmt.Errorf("%w: %w", ErrHistoryCount, err)
 	}
 	defer count.Clear()
-	return int(count.Val), nil
+	n, err := variantInt(count)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrHistoryCount, err)
+	}
+	return n, nil
 }
 
-// Close turns down any open update sessions.
+// ByKB returns the entries referencing kb, which may include a "KB" prefix, in history order.
+func (hc *History) ByKB(kb string) []*Entry {
+	kb = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(kb)), "KB")
+	var m []*Entry
+	for _, e := range hc.Entries {
+		for _, k := range e.KBArticleIDs {
+			if k == kb {
+				m = append(m, e)
+				break
+			}
+		}
+	}
+	return m
+}
+
+// Latest returns the most recent entry of each update, in history order. The highest RevisionNumber
+// breaks ties on Date, and entries without a Date are only kept when an update has no dated entry.
+// Entries without an UpdateID are all kept.
+func (hc *History) Latest() []*Entry {
+	latest := make(map[string]*Entry)
+	for _, e := range hc.Entries {
+		id := e.UpdateIdentity.UpdateID
+		if l, ok := latest[id]; !ok || newer(e, l) {
+			latest[id] = e
+		}
+	}
+	var l []*Entry
+	for _, e := range hc.Entries {
+		if e.UpdateIdentity.UpdateID == "" || latest[e.UpdateIdentity.UpdateID] == e {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// newer reports whether a was recorded after b.
+func newer(a, b *Entry) bool {
+	switch {
+	case a.Date.IsZero() != b.Date.IsZero():
+		return b.Date.IsZero()
+	case !a.Date.Equal(b.Date):
+		return a.Date.After(b.Date)
+	}
+	return a.UpdateIdentity.RevisionNumber > b.UpdateIdentity.RevisionNumber
+}
+
+// Present reports for each of updateIDs whether any entry is of that update. The map is keyed by the
+// requested IDs as given, which are matched case-insensitively.
+func (hc *History) Present(updateIDs []string) map[string]bool {
+	seen := make(map[string]bool)
+	for _, e := range hc.Entries {
+		seen[strings.ToLower(e.UpdateIdentity.UpdateID)] = true
+	}
+	p := make(map[string]bool, len(updateIDs))
+	for _, id := range updateIDs {
+		p[id] = seen[strings.ToLower(strings.TrimSpace(id))]
+	}
+	return p
+}
+
+// ByCategory returns the entries in the category with the GUID categoryID, in history order.
+func (hc *History) ByCategory(categoryID string) []*Entry {
+	var m []*Entry
+	for _, e := range hc.Entries {
+		if e.InCategory(categoryID) {
+			m = append(m, e)
+		}
+	}
+	return m
+}
+
+// BySeverity returns the entries with the MSRC severity s, such as "Critical", in history order.
+// Severities are matched case-insensitively.
+func (hc *History) BySeverity(s string) []*Entry {
+	var m []*Entry
+	for _, e := range hc.Entries {
+		if e.Severity != "" && strings.EqualFold(e.Severity, strings.TrimSpace(s)) {
+			m = append(m, e)
+		}
+	}
+	return m
+}
+
+// ByClient returns the entries recorded by the client with the ClientApplicationID appID, such as
+// the ID Cabbie stamps on its own installs, in history order. IDs are matched exactly once
+// surrounding whitespace is trimmed.
+func (hc *History) ByClient(appID string) []*Entry {
+	appID = strings.TrimSpace(appID)
+	var m []*Entry
+	for _, e := range hc.Entries {
+		if strings.TrimSpace(e.ClientApplicationID) == appID {
+			m = append(m, e)
+		}
+	}
+	return m
+}
+
+// Clients returns the number of entries recorded by each ClientApplicationID, trimmed of surrounding
+// whitespace.
+func (hc *History) Clients() map[string]int {
+	c := make(map[string]int)
+	for _, e := range hc.Entries {
+		c[strings.TrimSpace(e.ClientApplicationID)]++
+	}
+	return c
+}
+
+// HiddenSearch queries for the updates hidden on the device, installed or not.
+const HiddenSearch = "IsHidden=1"
+
+// LoadHidden sets Hidden on each entry whose update is currently hidden. Whether an update is hidden
+// is not recorded in its history, so this runs a search for the hidden updates, which costs as much
+// as any other update search and far more than reading the history itself.
+func (hc *History) LoadHidden(searchInterface *search.Searcher) error {
+	hidden, err := hiddenUpdateIDs(searchInterface)
+	if err != nil {
+		return err
+	}
+	markHidden(hc.Entries, hidden)
+	return nil
+}
+
+// ExcludeHidden returns a history of the entries whose update is not currently hidden, in history
+// order, after calling LoadHidden. The entries are shared with hc, which is still the one to close.
+func (hc *History) ExcludeHidden(searchInterface *search.Searcher) (*History, error) {
+	if err := hc.LoadHidden(searchInterface); err != nil {
+		return nil, err
+	}
+	var m []*Entry
+	for _, e := range hc.Entries {
+		if !e.Hidden {
+			m = append(m, e)
+		}
+	}
+	return &History{Entries: m}, nil
+}
+
+// hiddenUpdateIDs returns the lowercase UpdateIDs of the hidden updates, leaving the searcher's own
+// criteria untouched.
+func hiddenUpdateIDs(searchInterface *search.Searcher) (map[string]bool, error) {
+	c := searchInterface.Criteria
+	searchInterface.Criteria = HiddenSearch
+	defer func() { searchInterface.Criteria = c }()
+
+	uc, err := searchInterface.QueryUpdates()
+	if err != nil {
+		return nil, fmt.Errorf("error querying hidden updates: %v", err)
+	}
+	defer uc.Close()
+
+	ids := make(map[string]bool)
+	for _, u := range uc.Updates {
+		ids[strings.ToLower(u.Identity.UpdateID)] = true
+	}
+	return ids, nil
+}
+
+// markHidden sets Hidden on the entries whose UpdateID is in hidden, which are lowercase.
+func markHidden(entries []*Entry, hidden map[string]bool) {
+	for _, e := range entries {
+		e.Hidden = hidden[strings.ToLower(e.UpdateIdentity.UpdateID)]
+	}
+}
+
+// Drivers returns the driver update entries, in history order.
+func (hc *History) Drivers() []*Entry {
+	var m []*Entry
+	for _, e := range hc.Entries {
+		if e.IsDriver() {
+			m = append(m, e)
+		}
+	}
+	return m
+}
+
+// Uncategorized is the GroupByCategory key of entries without categories.
+const Uncategorized = "Uncategorized"
+
+// GroupByCategory returns the entries keyed by the Name of their PrimaryCategory, each group in
+// history order. Entries without categories are under Uncategorized.
+func (hc *History) GroupByCategory() map[string][]*Entry {
+	g := make(map[string][]*Entry)
+	for _, e := range hc.Entries {
+		name := Uncategorized
+		if c, ok := e.PrimaryCategory(); ok {
+			name = c.Name
+		}
+		g[name] = append(g[name], e)
+	}
+	return g
+}
+
+// SortByDate orders Entries by Date, oldest first when ascending. Entries with the same Date are
+// ordered by Title, and entries without a Date are always last.
+func (hc *History) SortByDate(ascending bool) {
+	sort.SliceStable(hc.Entries, func(i, j int) bool {
+		a, b := hc.Entries[i], hc.Entries[j]
+		switch {
+		case a.Date.IsZero() != b.Date.IsZero():
+			return b.Date.IsZero()
+		case !a.Date.Equal(b.Date):
+			return a.Date.Before(b.Date) == ascending
+		}
+		return a.Title < b.Title
+	})
+}
+
+// RebootPending returns the latest entry of each update that appears to be waiting on a reboot, in
+// history order. History does not record reboots, so this is a heuristic: the latest entry of an
+// update, chosen by Date as in Latest, is reboot pending when its operation is still InProgress, or
+// when it succeeded with an HResult reporting that a reboot is required to finish applying it
+// (WU_S_REBOOT_REQUIRED or ERROR_SUCCESS_REBOOT_REQUIRED). A later entry for the update, such as
+// the one recorded when the install completes after the reboot, clears the pending state.
+func (hc *History) RebootPending() []*Entry {
+	var p []*Entry
+	for _, e := range hc.Latest() {
+		if e.ResultCode == updates.ResultInProgress || (e.ResultCode.Succeeded() && rebootRequired(e.HResult)) {
+			p = append(p, e)
+		}
+	}
+	return p
+}
+
+func rebootRequired(hr int) bool {
+	switch errors.UpdateError(uint32(hr)) {
+	case errors.WU_S_REBOOT_REQUIRED, errors.ERROR_SUCCESS_REBOOT_REQUIRED:
+		return true
+	}
+	return false
+}
+
+// Equal reports whether e and other record the same operation: the same update identity, with
+// UpdateIDs matched case-insensitively, at the same Date with the same Operation and ResultCode.
+// Dates are compared to the second, as serialized snapshots do not keep fractional seconds. A nil
+// entry is only equal to another nil entry.
+func (e *Entry) Equal(other *Entry) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	return strings.EqualFold(e.UpdateIdentity.UpdateID, other.UpdateIdentity.UpdateID) &&
+		e.UpdateIdentity.RevisionNumber == other.UpdateIdentity.RevisionNumber &&
+		e.Date.Unix() == other.Date.Unix() &&
+		e.Operation == other.Operation &&
+		e.ResultCode == other.ResultCode
+}
+
+// Key returns a string identifying the fields compared by Equal, so entries that are Equal have the
+// same Key whichever collection they were read from. A nil entry has an empty Key.
+func (e *Entry) Key() string {
+	if e == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s.%d|%d|%d|%d", strings.ToLower(e.UpdateIdentity.UpdateID), e.UpdateIdentity.RevisionNumber, e.Date.Unix(), e.Operation, e.ResultCode)
+}
+
+// Merge returns a history of the entries of histories in order, keeping only the first of the
+// entries with the same Key. The entries are shared with histories, which are still the ones to
+// close. Nil histories and entries are skipped.
+func Merge(histories ...*History) *History {
+	m := &History{}
+	seen := make(map[string]bool)
+	for _, h := range histories {
+		if h == nil {
+			continue
+		}
+		for _, e := range h.Entries {
+			if e == nil {
+				continue
+			}
+			k := e.Key()
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			m.Entries = append(m.Entries, e)
+		}
+	}
+	return m
+}
+
+// Diff returns the entries of new missing from old and the entries of old missing from new, each in
+// the order of its history. Entries are matched by Key regardless of their order, so the same entries
+// Merge would combine are not reported. A nil history is treated as empty and nil entries are
+// skipped.
+func Diff(old, new *History) (added, removed []*Entry) {
+	var oldEntries, newEntries []*Entry
+	if old != nil {
+		oldEntries = old.Entries
+	}
+	if new != nil {
+		newEntries = new.Entries
+	}
+	return missing(newEntries, oldEntries), missing(oldEntries, newEntries)
+}
+
+// missing returns the entries of a without a match in b. Repeated entries are matched one for one.
+func missing(a, b []*Entry) []*Entry {
+	counts := make(map[string]int)
+	for _, e := range b {
+		if e != nil {
+			counts[e.Key()]++
+		}
+	}
+	var m []*Entry
+	for _, e := range a {
+		if e == nil {
+			continue
+		}
+		k := e.Key()
+		if counts[k] > 0 {
+			counts[k]--
+			continue
+		}
+		m = append(m, e)
+	}
+	return m
+}
+
+// Failed returns the entries whose operation failed or was aborted.
+func (hc *History) Failed() []*Entry {
+	var f []*Entry
+	for _, e := range hc.Entries {
+		if e.failed() {
+			f = append(f, e)
+		}
+	}
+	return f
+}
+
+// AllSucceeded reports whether no entry failed or was aborted, which is true of an empty history.
+func (hc *History) AllSucceeded() bool {
+	_, failed := hc.FirstFailure()
+	return !failed
+}
+
+// FirstFailure returns the first entry in Entries that failed or was aborted, if there is one.
+func (hc *History) FirstFailure() (*Entry, bool) {
+	for _, e := range hc.Entries {
+		if e.failed() {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// InLocation converts the Date of every entry to loc, such as time.Local for display. Dates are in
+// UTC after Get, which a nil loc restores. Entries without a Date are left unchanged.
+func (hc *History) InLocation(loc *time.Location) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, e := range hc.Entries {
+		if e != nil && !e.Date.IsZero() {
+			e.Date = e.Date.In(loc)
+		}
+	}
+}
+
+// Clone returns a deep copy of the history without its collection or the items of its entries, which
+// stays valid after hc is closed. Cloned entries cannot be read from COM again.
+func (hc *History) Clone() *History {
+	c := &History{Entries: make([]*Entry, len(hc.Entries))}
+	for i, e := range hc.Entries {
+		if e != nil {
+			c.Entries[i] = e.Clone()
+		}
+	}
+	for _, e := range hc.Errors {
+		c.Errors = append(c.Errors, EntryError{Index: e.Index, Errors: append([]error(nil), e.Errors...)})
+	}
+	return c
+}
+
+// Clone returns a deep copy of the decoded fields of the entry without its Item, so it stays valid
+// after the history is closed. A cloned entry cannot be read from COM again.
+func (e *Entry) Clone() *Entry {
+	c := *e
+	c.Item = nil
+	c.Categories = append([]updates.Category(nil), e.Categories...)
+	c.KBArticleIDs = append([]string(nil), e.KBArticleIDs...)
+	return &c
+}
+
+// Close turns down any open update sessions. It is safe to call on a partially populated history
+// and more than once. The collection and items are released on the thread of the history that
+// acquired them, or on the calling goroutine for a history that was not queried by this package.
 func (hc *History) Close() {
-	hc.IUpdateHistoryEntryCollection.Release()
-	hc.closeItems()
+	<-hc.startClose()
+}
+
+// CloseTimeout is like Close but stops waiting after d, returning an error so a caller such as a
+// stopping service is not blocked indefinitely. The release carries on in the background on the
+// thread that acquired the objects, and the history must not be used once CloseTimeout returns an
+// error. Each history releases on its own thread, so a release that hangs holds up no other history.
+func (hc *History) CloseTimeout(d time.Duration) error {
+	n := len(hc.Entries)
+	select {
+	case <-hc.startClose():
+		return nil
+	case <-time.After(d):
+		return fmt.Errorf("timed out after %s releasing %d history entries", d, n)
+	}
 }
 
-func (hc *History) closeItems() {
-	//TODO Using range causes application to occasionally hang.
-	for i := 0; i < len(hc.Entries); i++ {
-		hc.Entries[i].Item.Release()
+// releaseDispatch releases the collection and items of a history once done with them, replaced in
+// tests.
+var releaseDispatch = func(d *ole.IDispatch) { d.Release() }
+
+// startClose detaches the collection and items from the history and starts releasing them,
+// returning a channel closed once they are released. Only the first call releases anything. The
+// history is detached before startClose returns, so the background release shares nothing with
+// the caller.
+func (hc *History) startClose() <-chan struct{} {
+	hc.closeOnce.Do(func() {
+		objs := hc.detach()
+		done := make(chan struct{})
+		hc.closed = done
+		a := hc.apartment
+		go func() {
+			defer close(done)
+			if a == nil {
+				release(objs)
+				return
+			}
+			a.do(func() { release(objs) })
+			a.stop()
+		}()
+	})
+	return hc.closed
+}
+
+// detach clears the collection and the item of each entry of the history, skipping entries that
+// were never expanded, and returns the objects to release.
+func (hc *History) detach() []*ole.IDispatch {
+	var objs []*ole.IDispatch
+	if hc.IUpdateHistoryEntryCollection != nil {
+		objs = append(objs, hc.IUpdateHistoryEntryCollection)
+		hc.IUpdateHistoryEntryCollection = nil
+	}
+	for _, e := range hc.Entries {
+		if e == nil || e.Item == nil {
+			continue
+		}
+		objs = append(objs, e.Item)
+		e.Item = nil
 	}
+	return objs
 }
@@ -0,0 +1,1105 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package updatehistory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/cabbie/updates"
+	"github.com/go-ole/go-ole"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilterContains(t *testing.T) {
+	since := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc string
+		f    Filter
+		d    time.Time
+		want bool
+	}{
+		{"in range", Filter{Since: since, Until: until}, since.Add(time.Hour), true},
+		{"at since", Filter{Since: since, Until: until}, since, true},
+		{"at until", Filter{Since: since, Until: until}, until, false},
+		{"before since", Filter{Since: since, Until: until}, since.Add(-time.Second), false},
+		{"open until", Filter{Since: since}, until.AddDate(1, 0, 0), true},
+		{"open since", Filter{Until: until}, since.AddDate(-1, 0, 0), true},
+		{"undated excluded", Filter{Since: since, Until: until}, time.Time{}, false},
+		{"undated included", Filter{Since: since, Until: until, IncludeUndated: true}, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.f.Contains(tt.d); got != tt.want {
+			t.Errorf("%s: Contains(%v) = %t, want %t", tt.desc, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestFirstFailure(t *testing.T) {
+	succeeded := &Entry{Title: "succeeded", ResultCode: updates.ResultSucceeded}
+	aborted := &Entry{Title: "aborted", ResultCode: updates.ResultAborted}
+	failed := &Entry{Title: "failed", ResultCode: updates.ResultFailed}
+	for _, tt := range []struct {
+		desc    string
+		entries []*Entry
+		want    *Entry
+	}{
+		{"empty", nil, nil},
+		{"succeeded", []*Entry{succeeded, {ResultCode: updates.ResultSucceededWithErrors}}, nil},
+		{"failures", []*Entry{succeeded, aborted, failed}, aborted},
+	} {
+		h := &History{Entries: tt.entries}
+		got, ok := h.FirstFailure()
+		if got != tt.want || ok != (tt.want != nil) {
+			t.Errorf("%s: FirstFailure() = %v, %t, want %v", tt.desc, got, ok, tt.want)
+		}
+		if got := h.AllSucceeded(); got != (tt.want == nil) {
+			t.Errorf("%s: AllSucceeded() = %t, want %t", tt.desc, got, tt.want == nil)
+		}
+	}
+}
+
+func TestLast(t *testing.T) {
+	now := time.Date(2020, 10, 1, 12, 0, 0, 0, time.UTC)
+	orig := Now
+	defer func() { Now = orig }()
+	Now = func() time.Time { return now }
+
+	f := Last(24 * time.Hour)
+	for _, tt := range []struct {
+		d    time.Time
+		want bool
+	}{
+		{now.Add(-time.Hour), true},
+		{now.Add(-24 * time.Hour), true},
+		{now.Add(-25 * time.Hour), false},
+		{time.Time{}, false},
+	} {
+		if got := f.Contains(tt.d); got != tt.want {
+			t.Errorf("Last(24h).Contains(%v) = %t, want %t", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestUTCDate(t *testing.T) {
+	// go-ole may attach any location to the clock reading of a VARIANT date, which the WUA records in
+	// UTC.
+	pst := time.FixedZone("PST", -8*60*60)
+	got := utcDate(time.Date(2020, 6, 1, 12, 30, 0, 0, pst))
+	if want := time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC); !got.Equal(want) || got.Location() != time.UTC {
+		t.Errorf("utcDate() = %v, want %v", got, want)
+	}
+	if got := utcDate(time.Time{}); !got.IsZero() {
+		t.Errorf("utcDate() of zero time = %v, want zero", got)
+	}
+}
+
+func TestInLocation(t *testing.T) {
+	pst := time.FixedZone("PST", -8*60*60)
+	d := time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC)
+	h := &History{Entries: []*Entry{{Date: d}, {}}}
+
+	h.InLocation(pst)
+	if got := h.Entries[0].Date; !got.Equal(d) || got.Location() != pst || got.Hour() != 4 {
+		t.Errorf("InLocation(PST) Date = %v, want %v", got, d.In(pst))
+	}
+	h.InLocation(nil)
+	if got := h.Entries[0].Date; got.Location() != time.UTC {
+		t.Errorf("InLocation(nil) Date = %v, want UTC", got)
+	}
+	if !h.Entries[1].Date.IsZero() {
+		t.Errorf("InLocation() set the Date of an undated entry to %v", h.Entries[1].Date)
+	}
+}
+
+func TestFailed(t *testing.T) {
+	failed := &Entry{Title: "failed", ResultCode: updates.ResultFailed}
+	aborted := &Entry{Title: "aborted", ResultCode: updates.ResultAborted}
+	h := &History{Entries: []*Entry{
+		{Title: "succeeded", ResultCode: updates.ResultSucceeded},
+		failed,
+		{Title: "with errors", ResultCode: updates.ResultSucceededWithErrors},
+		aborted,
+	}}
+	if diff := cmp.Diff([]*Entry{failed, aborted}, h.Failed()); diff != "" {
+		t.Errorf("Failed() returned diff (-want +got):\n%s", diff)
+	}
+}
+
+// fakeItems returns n distinct items and an expander that titles each entry with the index of its
+// item after waiting delay, standing in for the property round trips of New.
+func fakeItems(n int, delay time.Duration) ([]*ole.IDispatch, func(*ole.IDispatch) (*Entry, []error)) {
+	items := make([]*ole.IDispatch, n)
+	index := make(map[*ole.IDispatch]int)
+	for i := range items {
+		items[i] = new(ole.IDispatch)
+		index[items[i]] = i
+	}
+	return items, func(item *ole.IDispatch) (*Entry, []error) {
+		i := index[item]
+		// Finish later items first when there is no fixed delay, to shake out ordering bugs.
+		d := delay
+		if d == 0 {
+			d = time.Duration(n-i) * 10 * time.Microsecond
+		}
+		time.Sleep(d)
+		return &Entry{Title: fmt.Sprint(i)}, nil
+	}
+}
+
+func TestExpandOrder(t *testing.T) {
+	items, fn := fakeItems(50, 0)
+	for _, n := range []int{1, 4, 16} {
+		entries, errs, err := expand(context.Background(), items, n, fn)
+		if err != nil {
+			t.Errorf("expand(%d workers) returned unexpected error: %v", n, err)
+		}
+		for i, e := range entries {
+			if errs[i] != nil {
+				t.Errorf("expand(%d workers) item %d returned errors: %v", n, i, errs[i])
+			}
+			if want := fmt.Sprint(i); e.Title != want {
+				t.Errorf("expand(%d workers) entry %d = %q, want %q", n, i, e.Title, want)
+			}
+		}
+	}
+}
+
+func BenchmarkExpand(b *testing.B) {
+	// Expansion waits on COM rather than the CPU, so it speeds up beyond the number of CPUs.
+	items, fn := fakeItems(200, 100*time.Microsecond)
+	for _, n := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("workers=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				expand(context.Background(), items, n, fn)
+			}
+		})
+	}
+}
+
+func TestEntryError(t *testing.T) {
+	e := EntryError{Index: 3, Errors: []error{fmt.Errorf("no such property")}}
+	if got, want := e.Error(), "errors expanding history entry 3: [no such property]"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func TestEnumerationError(t *testing.T) {
+	if err := (&History{}).Err(); err != nil {
+		t.Errorf("Err() of complete history = %v, want nil", err)
+	}
+
+	missing := fmt.Errorf("no such property")
+	h := &History{Errors: []EntryError{{Index: 3, Errors: []error{missing}}}}
+	err := h.Err()
+	var ee *EnumerationError
+	if !errors.As(err, &ee) || len(ee.Entries) != 1 {
+		t.Fatalf("Err() = %v, want an EnumerationError of one entry", err)
+	}
+	var entry EntryError
+	if !errors.As(err, &entry) || entry.Index != 3 {
+		t.Errorf("errors.As(%v) found EntryError %+v, want index 3", err, entry)
+	}
+	if !errors.Is(err, missing) {
+		t.Errorf("errors.Is(%v, %v) = false, want true", err, missing)
+	}
+}
+
+func TestClone(t *testing.T) {
+	e := &Entry{
+		Item:         new(ole.IDispatch),
+		Title:        "Update",
+		Categories:   []updates.Category{{Name: "Drivers"}},
+		KBArticleIDs: []string{"4561608"},
+	}
+	h := &History{IUpdateHistoryEntryCollection: new(ole.IDispatch), Entries: []*Entry{e, nil}}
+	c := h.Clone()
+	defer countReleases(make(map[*ole.IDispatch]int))()
+	h.Close()
+
+	want := []*Entry{{Title: "Update", Categories: []updates.Category{{Name: "Drivers"}}, KBArticleIDs: []string{"4561608"}}, nil}
+	if diff := cmp.Diff(want, c.Entries); diff != "" {
+		t.Errorf("Clone() returned diff (-want +got):\n%s", diff)
+	}
+	if c.IUpdateHistoryEntryCollection != nil {
+		t.Errorf("Clone() kept the collection")
+	}
+	e.Categories[0].Name = "changed"
+	if c.Entries[0].Categories[0].Name != "Drivers" {
+		t.Errorf("Clone() shares Categories with the original entry")
+	}
+}
+
+func TestClosePartial(t *testing.T) {
+	// A history abandoned midway holds entries that were never expanded and no collection.
+	h := &History{Entries: []*Entry{{Title: "expanded"}, nil}}
+	h.Close()
+	// Closing again must not release anything twice.
+	h.Close()
+	if err := h.CloseTimeout(time.Second); err != nil {
+		t.Errorf("CloseTimeout() of closed history returned unexpected error: %v", err)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	// Hold the release of one object open, as a hung COM call would.
+	hung, unblock := new(ole.IDispatch), make(chan struct{})
+	orig := releaseDispatch
+	defer func() { releaseDispatch = orig }()
+	releaseDispatch = func(d *ole.IDispatch) {
+		if d == hung {
+			<-unblock
+		}
+	}
+
+	h := &History{IUpdateHistoryEntryCollection: hung, apartment: newApartment()}
+	if err := h.CloseTimeout(10 * time.Millisecond); err == nil {
+		t.Errorf("CloseTimeout() of hung release returned nil error, want timeout")
+	}
+	if h.IUpdateHistoryEntryCollection != nil {
+		t.Errorf("CloseTimeout() left the collection on the history after timing out")
+	}
+
+	// Histories release on their own threads, so the hung release holds up no other history.
+	other := &History{IUpdateHistoryEntryCollection: new(ole.IDispatch), apartment: newApartment()}
+	if err := other.CloseTimeout(10 * time.Second); err != nil {
+		t.Errorf("CloseTimeout() of another history returned unexpected error: %v", err)
+	}
+
+	close(unblock)
+	h.Close()
+}
+
+// countReleases replaces releaseDispatch with one counting the releases of each object, returning
+// a function restoring the original.
+func countReleases(released map[*ole.IDispatch]int) func() {
+	orig := releaseDispatch
+	var mu sync.Mutex
+	releaseDispatch = func(d *ole.IDispatch) {
+		mu.Lock()
+		defer mu.Unlock()
+		released[d]++
+	}
+	return func() { releaseDispatch = orig }
+}
+
+// goroutineID returns the ID of the calling goroutine. An apartment is locked to its thread, so
+// running on its goroutine means running on its thread.
+func goroutineID() string {
+	b := make([]byte, 64)
+	return strings.Fields(string(b[:runtime.Stack(b, false)]))[1]
+}
+
+func TestCloseStress(t *testing.T) {
+	const histories, entries = 200, 500
+	var mu sync.Mutex
+	acquired := make(map[*ole.IDispatch]string)
+	released := make(map[*ole.IDispatch][]string)
+	orig := releaseDispatch
+	defer func() { releaseDispatch = orig }()
+	releaseDispatch = func(d *ole.IDispatch) {
+		id := goroutineID()
+		mu.Lock()
+		defer mu.Unlock()
+		released[d] = append(released[d], id)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, histories)
+	for i := 0; i < histories; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Acquire the objects on the apartment of the history, as query does.
+			h := &History{apartment: newApartment()}
+			h.apartment.do(func() {
+				id := goroutineID()
+				h.IUpdateHistoryEntryCollection = new(ole.IDispatch)
+				objs := []*ole.IDispatch{h.IUpdateHistoryEntryCollection}
+				for j := 0; j < entries; j++ {
+					h.Entries = append(h.Entries, &Entry{Item: new(ole.IDispatch)})
+					objs = append(objs, h.Entries[j].Item)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				for _, d := range objs {
+					acquired[d] = id
+				}
+			})
+			// Close twice from different goroutines, as a service stopping mid run might.
+			go h.Close()
+			if err := h.CloseTimeout(10 * time.Second); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if len(released) != len(acquired) {
+		t.Errorf("Close() released %d objects, want %d", len(released), len(acquired))
+	}
+	for d, id := range acquired {
+		if got := released[d]; len(got) != 1 || got[0] != id {
+			t.Fatalf("Close() released an object on goroutines %v, want once on goroutine %s that acquired it", got, id)
+		}
+	}
+}
+
+func TestKBArticleIDs(t *testing.T) {
+	for _, tt := range []struct {
+		title string
+		want  []string
+	}{
+		{"Security Update for Windows (KB5034441)", []string{"5034441"}},
+		{"2020-06 Cumulative Update (KB4561608) superseding KB4556799 and kb4561608", []string{"4561608", "4556799"}},
+		{"Security Intelligence Update for Microsoft Defender Antivirus", nil},
+		{"NOTAKB123", nil},
+	} {
+		if diff := cmp.Diff(tt.want, kbArticleIDs(tt.title)); diff != "" {
+			t.Errorf("kbArticleIDs(%q) returned diff (-want +got):\n%s", tt.title, diff)
+		}
+	}
+}
+
+func TestByKB(t *testing.T) {
+	first := &Entry{Title: "first", KBArticleIDs: []string{"5034441"}}
+	second := &Entry{Title: "second", KBArticleIDs: []string{"4561608", "5034441"}}
+	h := &History{Entries: []*Entry{first, {Title: "other", KBArticleIDs: []string{"4561608"}}, second}}
+	for _, kb := range []string{"5034441", "KB5034441", " kb5034441 "} {
+		if diff := cmp.Diff([]*Entry{first, second}, h.ByKB(kb)); diff != "" {
+			t.Errorf("ByKB(%q) returned diff (-want +got):\n%s", kb, diff)
+		}
+	}
+	if got := h.ByKB("123"); got != nil {
+		t.Errorf("ByKB(%q) = %v, want nil", "123", got)
+	}
+}
+
+func TestExpandCancel(t *testing.T) {
+	const stopAfter = 5
+	items, fn := fakeItems(100, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mu sync.Mutex
+	var calls int
+	counting := func(item *ole.IDispatch) (*Entry, []error) {
+		mu.Lock()
+		calls++
+		if calls == stopAfter {
+			cancel()
+		}
+		mu.Unlock()
+		return fn(item)
+	}
+
+	entries, _, err := expand(ctx, items, 1, counting)
+	if err != context.Canceled {
+		t.Errorf("expand() error = %v, want %v", err, context.Canceled)
+	}
+	// The item being dispatched when the context is cancelled may still be expanded.
+	if calls > stopAfter+1 {
+		t.Errorf("expand() expanded %d items after cancelling at %d", calls, stopAfter)
+	}
+	for i := calls; i < len(entries); i++ {
+		if entries[i] != nil {
+			t.Errorf("expand() expanded item %d after cancellation", i)
+		}
+	}
+}
+
+func TestLatest(t *testing.T) {
+	first := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	retried := &Entry{Title: "a retried", UpdateIdentity: updates.Identity{UpdateID: "a"}, Date: second}
+	revised := &Entry{Title: "b revised", UpdateIdentity: updates.Identity{UpdateID: "b", RevisionNumber: 2}, Date: first}
+	dated := &Entry{Title: "c dated", UpdateIdentity: updates.Identity{UpdateID: "c"}, Date: first}
+	undated := &Entry{Title: "d undated", UpdateIdentity: updates.Identity{UpdateID: "d"}}
+	noID := &Entry{Title: "no id", Date: first}
+	entries := []*Entry{
+		retried,
+		{Title: "a failed", UpdateIdentity: updates.Identity{UpdateID: "a"}, Date: first},
+		{Title: "b original", UpdateIdentity: updates.Identity{UpdateID: "b", RevisionNumber: 1}, Date: first},
+		revised,
+		{Title: "c undated", UpdateIdentity: updates.Identity{UpdateID: "c"}},
+		dated,
+		undated,
+		noID,
+		noID,
+	}
+	h := &History{Entries: entries}
+	original := append([]*Entry(nil), entries...)
+
+	want := []*Entry{retried, revised, dated, undated, noID, noID}
+	if diff := cmp.Diff(want, h.Latest()); diff != "" {
+		t.Errorf("Latest() returned diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(original, h.Entries); diff != "" {
+		t.Errorf("Latest() modified Entries (-want +got):\n%s", diff)
+	}
+}
+
+func TestSortByDate(t *testing.T) {
+	first := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2020, 10, 1, 0, 0, 0, 0, time.UTC)
+	entries := func() []*Entry {
+		return []*Entry{
+			{Title: "undated"},
+			{Title: "b", Date: first},
+			{Title: "newest", Date: second},
+			{Title: "a", Date: first},
+		}
+	}
+	titles := func(es []*Entry) []string {
+		var t []string
+		for _, e := range es {
+			t = append(t, e.Title)
+		}
+		return t
+	}
+	for _, tt := range []struct {
+		ascending bool
+		want      []string
+	}{
+		{true, []string{"a", "b", "newest", "undated"}},
+		{false, []string{"newest", "a", "b", "undated"}},
+	} {
+		h := &History{Entries: entries()}
+		h.SortByDate(tt.ascending)
+		if diff := cmp.Diff(tt.want, titles(h.Entries)); diff != "" {
+			t.Errorf("SortByDate(%t) returned diff (-want +got):\n%s", tt.ascending, diff)
+		}
+	}
+}
+
+func TestNewMatchingSkips(t *testing.T) {
+	reject := func(*Entry) (bool, error) { return false, nil }
+	if e, errs := newMatching(new(ole.IDispatch), reject, nil); e != nil || errs != nil {
+		t.Errorf("newMatching() of rejected item = %v, %v, want nil, nil", e, errs)
+	}
+	fail := func(*Entry) (bool, error) { return false, fmt.Errorf("no UpdateIdentity") }
+	if e, errs := newMatching(new(ole.IDispatch), fail, nil); e != nil || len(errs) != 1 {
+		t.Errorf("newMatching() of unreadable item = %v, %v, want nil and one error", e, errs)
+	}
+}
+
+func TestQueryCount(t *testing.T) {
+	for _, tt := range []struct {
+		total, limit, want int
+	}{
+		{100, 10, 10},
+		{5, 10, 5},
+		{100, 0, 100},
+		{0, 10, 0},
+	} {
+		if got := queryCount(tt.total, tt.limit); got != tt.want {
+			t.Errorf("queryCount(%d, %d) = %d, want %d", tt.total, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestGetRecentInvalid(t *testing.T) {
+	for _, n := range []int{0, -1} {
+		if _, err := GetRecent(nil, n); err == nil {
+			t.Errorf("GetRecent(%d) returned nil error, want error", n)
+		}
+	}
+}
+
+func TestLogFieldErrors(t *testing.T) {
+	failed := []fieldError{{property: "Categories", err: fmt.Errorf("call rejected")}}
+
+	// Without a logger nothing is recorded.
+	logFieldErrors("Update", failed)
+
+	var b bytes.Buffer
+	SetLogger(slog.New(slog.NewJSONHandler(&b, nil)))
+	defer SetLogger(nil)
+	logFieldErrors("Update", failed)
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &got); err != nil {
+		t.Fatalf("logFieldErrors() wrote %q, which is not one JSON record: %v", b.String(), err)
+	}
+	for k, want := range map[string]string{"level": "WARN", "property": "Categories", "title": "Update", "error": "call rejected"} {
+		if got[k] != want {
+			t.Errorf("logFieldErrors() record %s = %v, want %q", k, got[k], want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	e := &Entry{
+		Title:               "  Security Update (KB5034441)\r\n",
+		UninstallationNotes: "Line one\r\nLine two\rLine three\r\n",
+		SupportURL:          " https://support.microsoft.com/help/5034441 ",
+	}
+	e.normalize()
+	want := &Entry{
+		Title:               "Security Update (KB5034441)",
+		UninstallationNotes: "Line one\nLine two\nLine three",
+		SupportURL:          "https://support.microsoft.com/help/5034441",
+	}
+	if diff := cmp.Diff(want, e); diff != "" {
+		t.Errorf("normalize() returned diff (-want +got):\n%s", diff)
+	}
+	if !e.HasSupportURL() {
+		t.Errorf("HasSupportURL() = false, want true")
+	}
+
+	for _, u := range []string{"", "   ", "support.microsoft.com/help", "ftp://example.com/kb", "http://", "https://%zz"} {
+		e := &Entry{SupportURL: u}
+		e.normalize()
+		if e.HasSupportURL() {
+			t.Errorf("normalize() kept invalid SupportURL %q as %q", u, e.SupportURL)
+		}
+	}
+}
+
+func TestByCategory(t *testing.T) {
+	security := &Entry{Title: "security", Categories: []updates.Category{
+		{Name: "Windows 10", CategoryID: "a3c2375d-0c8a-42f9-bce0-28333e198407"},
+		{Name: "Security Updates", CategoryID: "0fa1201d-4330-4fa8-8ae9-b877473b6441"},
+	}}
+	driver := &Entry{Title: "driver", Categories: []updates.Category{{Name: "Drivers", CategoryID: updates.CategoryDrivers}}}
+	h := &History{Entries: []*Entry{security, driver, {Title: "uncategorized"}}}
+
+	if diff := cmp.Diff([]*Entry{security}, h.ByCategory(updates.CategorySecurityUpdates)); diff != "" {
+		t.Errorf("ByCategory(SecurityUpdates) returned diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]*Entry{driver}, h.ByCategory(strings.ToLower(updates.CategoryDrivers))); diff != "" {
+		t.Errorf("ByCategory(Drivers) returned diff (-want +got):\n%s", diff)
+	}
+	if got := h.ByCategory(updates.CategoryCriticalUpdates); got != nil {
+		t.Errorf("ByCategory(CriticalUpdates) = %v, want nil", got)
+	}
+}
+
+func TestPresent(t *testing.T) {
+	id := func(s string) updates.Identity { return updates.Identity{UpdateID: s} }
+	h := &History{Entries: []*Entry{{UpdateIdentity: id("ABC")}, {UpdateIdentity: id("def")}, {UpdateIdentity: id("abc")}}}
+	want := map[string]bool{"abc": true, "DEF": true, "123": false}
+	if diff := cmp.Diff(want, h.Present([]string{"abc", "DEF", "123"})); diff != "" {
+		t.Errorf("Present() returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDrivers(t *testing.T) {
+	product := updates.Category{Name: "Windows 10", Type: "Product", CategoryID: "a3c2375d-0c8a-42f9-bce0-28333e198407"}
+	mixed := &Entry{Title: "mixed", Categories: []updates.Category{
+		product,
+		{Name: "Drivers", Type: "UpdateClassification", CategoryID: strings.ToLower(updates.CategoryDrivers)},
+	}}
+	classified := &Entry{Title: "classified", Categories: []updates.Category{{Name: "Drivers", Type: "UpdateClassification"}}}
+	software := &Entry{Title: "software", Categories: []updates.Category{
+		product,
+		{Name: "Security Updates", Type: "UpdateClassification", CategoryID: updates.CategorySecurityUpdates},
+	}}
+	// A product that happens to be named Drivers is not a classification.
+	named := &Entry{Title: "named", Categories: []updates.Category{{Name: "Drivers", Type: "Product"}}}
+	h := &History{Entries: []*Entry{mixed, software, classified, named, {Title: "uncategorized"}}}
+
+	if diff := cmp.Diff([]*Entry{mixed, classified}, h.Drivers()); diff != "" {
+		t.Errorf("Drivers() returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestTitleSeverity(t *testing.T) {
+	for _, tt := range []struct {
+		title string
+		want  string
+	}{
+		{"2020-10 Security Update (Critical) for Windows 10 (KB4580325)", "Critical"},
+		{"Security Update for Microsoft Office - IMPORTANT", "Important"},
+		{"2020-10 Cumulative Update for Windows 10 (KB4579311)", ""},
+		{"Security Update for Lowell Viewer", ""},
+	} {
+		if got := titleSeverity(tt.title); got != tt.want {
+			t.Errorf("titleSeverity(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestBySeverity(t *testing.T) {
+	critical := &Entry{Title: "critical", Severity: "Critical"}
+	important := &Entry{Title: "important", Severity: "Important"}
+	h := &History{Entries: []*Entry{critical, important, {Title: "unrated"}}}
+
+	if diff := cmp.Diff([]*Entry{critical}, h.BySeverity("critical")); diff != "" {
+		t.Errorf("BySeverity(critical) returned diff (-want +got):\n%s", diff)
+	}
+	if got := h.BySeverity(""); got != nil {
+		t.Errorf("BySeverity(\"\") = %v, want nil", got)
+	}
+}
+
+func TestClients(t *testing.T) {
+	cabbie := &Entry{Title: "cabbie", ClientApplicationID: "Cabbie"}
+	padded := &Entry{Title: "padded", ClientApplicationID: " Cabbie\t"}
+	h := &History{Entries: []*Entry{
+		cabbie,
+		{Title: "agent", ClientApplicationID: "UpdateOrchestrator"},
+		padded,
+		{Title: "case", ClientApplicationID: "cabbie"},
+	}}
+
+	if diff := cmp.Diff([]*Entry{cabbie, padded}, h.ByClient("Cabbie ")); diff != "" {
+		t.Errorf("ByClient(Cabbie) returned diff (-want +got):\n%s", diff)
+	}
+	want := map[string]int{"Cabbie": 2, "UpdateOrchestrator": 1, "cabbie": 1}
+	if diff := cmp.Diff(want, h.Clients()); diff != "" {
+		t.Errorf("Clients() returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestMarkHidden(t *testing.T) {
+	id := func(s string) updates.Identity { return updates.Identity{UpdateID: s} }
+	hidden := &Entry{Title: "hidden", UpdateIdentity: id("ABC")}
+	// An update shown again since an earlier lookup is no longer hidden.
+	shown := &Entry{Title: "shown", UpdateIdentity: id("def"), Hidden: true}
+	markHidden([]*Entry{hidden, shown}, map[string]bool{"abc": true})
+	if !hidden.Hidden || shown.Hidden {
+		t.Errorf("markHidden() set Hidden to %t and %t, want true and false", hidden.Hidden, shown.Hidden)
+	}
+}
+
+func TestGroupByCategory(t *testing.T) {
+	product := updates.Category{Name: "Windows 10", Type: "Product", CategoryID: "a3c2375d-0c8a-42f9-bce0-28333e198407"}
+	security := updates.Category{Name: "Security Updates", Type: "UpdateClassification", CategoryID: strings.ToLower(updates.CategorySecurityUpdates)}
+	drivers := updates.Category{Name: "Drivers", Type: "UpdateClassification", CategoryID: updates.CategoryDrivers}
+	feature := updates.Category{Name: "Upgrades", Type: "UpdateClassification", CategoryID: "3689bdc8-b205-4af4-8d4a-a63924c5e9d5"}
+
+	securityUpdate := &Entry{Title: "security", Categories: []updates.Category{product, security}}
+	driverUpdate := &Entry{Title: "driver", Categories: []updates.Category{security, drivers, product}}
+	featureUpdate := &Entry{Title: "feature", Categories: []updates.Category{product, feature}}
+	productUpdate := &Entry{Title: "product", Categories: []updates.Category{product}}
+	uncategorized := &Entry{Title: "uncategorized"}
+	h := &History{Entries: []*Entry{securityUpdate, driverUpdate, featureUpdate, productUpdate, uncategorized}}
+
+	want := map[string][]*Entry{
+		"Security Updates": {securityUpdate},
+		"Drivers":          {driverUpdate},
+		"Upgrades":         {featureUpdate},
+		"Windows 10":       {productUpdate},
+		Uncategorized:      {uncategorized},
+	}
+	if diff := cmp.Diff(want, h.GroupByCategory()); diff != "" {
+		t.Errorf("GroupByCategory() returned diff (-want +got):\n%s", diff)
+	}
+	if _, ok := uncategorized.PrimaryCategory(); ok {
+		t.Errorf("PrimaryCategory() of uncategorized entry returned true, want false")
+	}
+}
+
+func TestRebootPending(t *testing.T) {
+	first := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2020, 9, 2, 0, 0, 0, 0, time.UTC)
+	id := func(s string) updates.Identity { return updates.Identity{UpdateID: s} }
+	running := &Entry{Title: "running", UpdateIdentity: id("a"), ResultCode: updates.ResultInProgress, Date: second}
+	needsReboot := &Entry{Title: "needs reboot", UpdateIdentity: id("b"), ResultCode: updates.ResultSucceeded, HResult: 0x00240005, Date: first}
+	win32Reboot := &Entry{Title: "win32 reboot", UpdateIdentity: id("c"), ResultCode: updates.ResultSucceededWithErrors, HResult: -2147021886, Date: first}
+	h := &History{Entries: []*Entry{
+		running,
+		{Title: "running earlier", UpdateIdentity: id("a"), ResultCode: updates.ResultFailed, Date: first},
+		// The reboot completed the install of d.
+		{Title: "completed", UpdateIdentity: id("d"), ResultCode: updates.ResultSucceeded, Date: second},
+		{Title: "before reboot", UpdateIdentity: id("d"), ResultCode: updates.ResultInProgress, Date: first},
+		needsReboot,
+		win32Reboot,
+		{Title: "failed reboot code", UpdateIdentity: id("e"), ResultCode: updates.ResultFailed, HResult: 0x00240005, Date: first},
+	}}
+	if diff := cmp.Diff([]*Entry{running, needsReboot, win32Reboot}, h.RebootPending()); diff != "" {
+		t.Errorf("RebootPending() returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	night := time.Date(2020, 9, 1, 2, 0, 0, 0, time.UTC)
+	entry := func(id string, rev int, d time.Time) *Entry {
+		return &Entry{Title: id, UpdateIdentity: updates.Identity{UpdateID: id, RevisionNumber: rev}, Date: d}
+	}
+	kept := entry("a", 1, night)
+	dropped := entry("b", 1, night)
+	installed := entry("c", 1, night.Add(time.Hour))
+	revised := entry("a", 2, night.Add(time.Hour))
+	old := &History{Entries: []*Entry{kept, dropped}}
+	// Snapshots read back from JSON lose fractional seconds and may be ordered differently.
+	new := &History{Entries: []*Entry{installed, revised, entry("A", 1, night.Add(300*time.Millisecond))}}
+
+	added, removed := Diff(old, new)
+	if diff := cmp.Diff([]*Entry{installed, revised}, added); diff != "" {
+		t.Errorf("Diff() added returned diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]*Entry{dropped}, removed); diff != "" {
+		t.Errorf("Diff() removed returned diff (-want +got):\n%s", diff)
+	}
+
+	// Entries recording different results of the same update at the same time are not matched.
+	failed := entry("b", 1, night)
+	failed.ResultCode = updates.ResultFailed
+	added, removed = Diff(old, &History{Entries: []*Entry{kept, nil, failed}})
+	if diff := cmp.Diff([]*Entry{failed}, added); diff != "" {
+		t.Errorf("Diff() of a changed result added returned diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]*Entry{dropped}, removed); diff != "" {
+		t.Errorf("Diff() of a changed result removed returned diff (-want +got):\n%s", diff)
+	}
+
+	added, removed = Diff(nil, old)
+	if diff := cmp.Diff(old.Entries, added); diff != "" || removed != nil {
+		t.Errorf("Diff(nil, old) = %v, %v, want every entry added", added, removed)
+	}
+	added, removed = Diff(old, nil)
+	if diff := cmp.Diff(old.Entries, removed); diff != "" || added != nil {
+		t.Errorf("Diff(old, nil) = %v, %v, want every entry removed", added, removed)
+	}
+}
+
+func TestResultCounter(t *testing.T) {
+	since := time.Date(2020, 9, 1, 0, 0, 0, 0, time.UTC)
+	c := &resultCounter{since: since, counts: make(map[updates.OperationResultCode]int)}
+	c.add(since.Add(time.Hour), updates.ResultFailed)
+	c.add(since, updates.ResultFailed)
+	c.add(since.Add(time.Hour), updates.ResultSucceeded)
+	c.add(since.Add(-time.Hour), updates.ResultFailed)
+	c.add(time.Time{}, updates.ResultAborted)
+
+	want := map[updates.OperationResultCode]int{updates.ResultFailed: 2, updates.ResultSucceeded: 1}
+	if diff := cmp.Diff(want, c.counts); diff != "" {
+		t.Errorf("resultCounter returned diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	released := make(map[*ole.IDispatch]int)
+	defer countReleases(released)()
+	items, expand := fakeItems(10, 0)
+	itemAt := func(i int) (*ole.IDispatch, error) { return items[i], nil }
+	// Fail to expand the third item, which Walk skips.
+	skipping := func(item *ole.IDispatch) (*Entry, []error) {
+		if item == items[2] {
+			return nil, []error{fmt.Errorf("no such property")}
+		}
+		return expand(item)
+	}
+
+	var got []string
+	stop := fmt.Errorf("stop")
+	err := walk(len(items), itemAt, skipping, func(e *Entry) error {
+		got = append(got, e.Title)
+		if len(got) == 4 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("walk() returned %v, want %v", err, stop)
+	}
+	if diff := cmp.Diff([]string{"0", "1", "3", "4"}, got); diff != "" {
+		t.Errorf("walk() visited diff (-want +got):\n%s", diff)
+	}
+	// Every item walked, including the skipped one, is released before walk returns.
+	for i, d := range items[:5] {
+		if released[d] != 1 {
+			t.Errorf("walk() released item %d %d times, want 1", i, released[d])
+		}
+	}
+}
+
+func TestIdentityOfRevision(t *testing.T) {
+	orig := getPropertyRetry
+	defer func() { getPropertyRetry = orig }()
+	getPropertyRetry = func(d *ole.IDispatch, name string, args ...interface{}) (*ole.VARIANT, error) {
+		if name == "RevisionNumber" {
+			// variantInt accepts any integer VARIANT, not only a VT_I4.
+			v := ole.NewVariant(ole.VT_I8, 201)
+			return &v, nil
+		}
+		return &ole.VARIANT{}, nil
+	}
+
+	got, err := identityOf(new(ole.IDispatch))
+	if err != nil {
+		t.Fatalf("identityOf() returned unexpected error: %v", err)
+	}
+	if got.RevisionNumber != 201 {
+		t.Errorf("identityOf() RevisionNumber = %d, want 201", got.RevisionNumber)
+	}
+}
+
+func TestToIdentityNil(t *testing.T) {
+	orig := getPropertyRetry
+	defer func() { getPropertyRetry = orig }()
+	identity := new(ole.IDispatch)
+	getPropertyRetry = func(d *ole.IDispatch, name string, args ...interface{}) (*ole.VARIANT, error) {
+		// Every property of the identity is empty.
+		return &ole.VARIANT{}, nil
+	}
+
+	got, err := identityOf(identity)
+	if err != nil {
+		t.Fatalf("identityOf() returned unexpected error: %v", err)
+	}
+	if got != (updates.Identity{}) {
+		t.Errorf("identityOf() = %+v, want zero Identity", got)
+	}
+
+	// An entry may have no identity at all.
+	got, err = (&Entry{Item: new(ole.IDispatch)}).toIdentity("UpdateIdentity")
+	if err != nil {
+		t.Fatalf("toIdentity() returned unexpected error: %v", err)
+	}
+	if got != (updates.Identity{}) {
+		t.Errorf("toIdentity() = %+v, want zero Identity", got)
+	}
+}
+
+// fakeCategories returns the category items of entries history entries, each with perEntry
+// categories drawn from distinct CategoryIDs, and a getString reading them that counts its calls.
+func fakeCategories(entries, distinct, perEntry int, calls *int) ([][]*ole.IDispatch, func(*ole.IDispatch, string) (string, error)) {
+	ids := make(map[*ole.IDispatch]int)
+	items := make([][]*ole.IDispatch, entries)
+	for i := range items {
+		for j := 0; j < perEntry; j++ {
+			d := new(ole.IDispatch)
+			ids[d] = (i + j) % distinct
+			items[i] = append(items[i], d)
+		}
+	}
+	var mu sync.Mutex
+	return items, func(d *ole.IDispatch, property string) (string, error) {
+		mu.Lock()
+		*calls++
+		mu.Unlock()
+		return fmt.Sprintf("%s %d", property, ids[d]), nil
+	}
+}
+
+func resetCategoryCache() {
+	categoryCache.Lock()
+	categoryCache.m = make(map[string]updates.Category)
+	categoryCache.Unlock()
+}
+
+func TestCategoryCache(t *testing.T) {
+	orig := getString
+	defer func() { getString = orig }()
+	defer resetCategoryCache()
+	resetCategoryCache()
+
+	var calls int
+	items, fake := fakeCategories(2, 1, 1, &calls)
+	getString = fake
+	for _, e := range items {
+		got, err := category(e[0])
+		if err != nil {
+			t.Fatalf("category() returned unexpected error: %v", err)
+		}
+		want := updates.Category{Name: "Name 0", Type: "Type 0", CategoryID: "CategoryID 0"}
+		if got != want {
+			t.Errorf("category() = %+v, want %+v", got, want)
+		}
+	}
+	// The second entry only reads the CategoryID.
+	if calls != 4 {
+		t.Errorf("category() read %d properties, want 4", calls)
+	}
+}
+
+func BenchmarkCategories(b *testing.B) {
+	orig := getString
+	defer func() { getString = orig }()
+	defer resetCategoryCache()
+
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf("cached=%t", cached), func(b *testing.B) {
+			var calls int
+			items, fake := fakeCategories(5000, 20, 3, &calls)
+			getString = fake
+			for i := 0; i < b.N; i++ {
+				resetCategoryCache()
+				for _, e := range items {
+					if !cached {
+						resetCategoryCache()
+					}
+					for _, d := range e {
+						category(d)
+					}
+				}
+			}
+			b.ReportMetric(float64(calls)/float64(b.N), "getproperty/op")
+		})
+	}
+}
+
+func TestVariantInt(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		v       ole.VARIANT
+		want    int
+		wantErr bool
+	}{
+		{"empty", ole.VARIANT{}, 0, false},
+		// Only the low 32 bits of Val are the value of a VT_I4.
+		{"int32 with high bits", ole.NewVariant(ole.VT_I4, 1<<32|5), 5, false},
+		{"int64", ole.NewVariant(ole.VT_I8, 1<<33), 1 << 33, false},
+		{"string", ole.NewVariant(ole.VT_BSTR, 0), 0, true},
+	} {
+		got, err := variantInt(&tt.v)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: variantInt() returned error %v, want error %t", tt.desc, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("%s: variantInt() = %d, want %d", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestNewFields(t *testing.T) {
+	orig := getPropertyRetry
+	defer func() { getPropertyRetry = orig }()
+	getPropertyRetry = func(d *ole.IDispatch, name string, args ...interface{}) (*ole.VARIANT, error) {
+		return nil, fmt.Errorf("no such property %q", name)
+	}
+
+	e, fields := NewFields(new(ole.IDispatch))
+	var got []string
+	for k := range fields {
+		got = append(got, k)
+	}
+	// Every field is read from the property of the same name, except those derived from others.
+	var want []string
+	entry := reflect.TypeOf(*e)
+	for i := 0; i < entry.NumField(); i++ {
+		switch f := entry.Field(i).Name; f {
+		case "Item", "KBArticleIDs", "Severity", "Hidden":
+		default:
+			want = append(want, f)
+		}
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("NewFields() returned error keys diff (-want +got):\n%s", diff)
+	}
+	if err := fields["Description"]; err == nil || !strings.Contains(err.Error(), `"Description"`) {
+		t.Errorf("NewFields() Description error = %v, want the error reading Description", err)
+	}
+
+	if _, errs := New(new(ole.IDispatch)); len(errs) != len(want) {
+		t.Errorf("New() returned %d errors, want %d", len(errs), len(want))
+	}
+}
+
+// countProperties replaces getPropertyRetry with one returning empty values that counts its calls,
+// returning a function restoring the original.
+func countProperties(calls *int) func() {
+	orig := getPropertyRetry
+	var mu sync.Mutex
+	getPropertyRetry = func(d *ole.IDispatch, name string, args ...interface{}) (*ole.VARIANT, error) {
+		mu.Lock()
+		*calls++
+		mu.Unlock()
+		return &ole.VARIANT{}, nil
+	}
+	return func() { getPropertyRetry = orig }
+}
+
+func TestNewWithFields(t *testing.T) {
+	var calls int
+	defer countProperties(&calls)()
+
+	if _, errs := NewWithFields(new(ole.IDispatch), MinimalFields); errs != nil {
+		t.Fatalf("NewWithFields(MinimalFields) returned unexpected errors: %v", errs)
+	}
+	if calls != len(MinimalFields) {
+		t.Errorf("NewWithFields(MinimalFields) read %d properties, want %d", calls, len(MinimalFields))
+	}
+	for _, fields := range [][]string{{"Title", "NoSuchField"}, {"Item"}} {
+		if _, errs := NewWithFields(new(ole.IDispatch), fields); len(errs) != 1 {
+			t.Errorf("NewWithFields(%q) returned errors %v, want one", fields, errs)
+		}
+	}
+}
+
+func BenchmarkNewWithFields(b *testing.B) {
+	var all []string
+	t := reflect.TypeOf(Entry{})
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i).Name; f != "Item" && f != "KBArticleIDs" && f != "Hidden" {
+			all = append(all, f)
+		}
+	}
+	for _, bm := range []struct {
+		name   string
+		fields []string
+	}{
+		{"full", all},
+		{"minimal", MinimalFields},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var calls int
+			defer countProperties(&calls)()
+			item := new(ole.IDispatch)
+			for i := 0; i < b.N; i++ {
+				NewWithFields(item, bm.fields)
+			}
+			b.ReportMetric(float64(calls)/float64(b.N), "getproperty/op")
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	d := time.Date(2020, 6, 1, 12, 30, 0, 0, time.UTC)
+	id := updates.Identity{UpdateID: "ABC", RevisionNumber: 201}
+	installed := &Entry{Item: new(ole.IDispatch), Title: "installed", UpdateIdentity: id, Date: d, Operation: updates.OperationInstallation, ResultCode: updates.ResultSucceeded}
+	// The same entry read through another searcher differs by its COM item and location.
+	again := &Entry{Item: new(ole.IDispatch), Title: "again", UpdateIdentity: updates.Identity{UpdateID: "abc", RevisionNumber: 201}, Date: d.In(time.FixedZone("PST", -8*60*60)), Operation: updates.OperationInstallation, ResultCode: updates.ResultSucceeded}
+	failed := &Entry{Title: "failed", UpdateIdentity: id, Date: d, Operation: updates.OperationInstallation, ResultCode: updates.ResultFailed}
+	uninstalled := &Entry{Title: "uninstalled", UpdateIdentity: id, Date: d, Operation: updates.OperationUninstallation, ResultCode: updates.ResultSucceeded}
+
+	if !installed.Equal(again) || installed.Key() != again.Key() {
+		t.Errorf("Equal() = %t with keys %q and %q, want equal entries", installed.Equal(again), installed.Key(), again.Key())
+	}
+	for _, e := range []*Entry{failed, uninstalled} {
+		if installed.Equal(e) || installed.Key() == e.Key() {
+			t.Errorf("Equal(%s) = %t with keys %q and %q, want different entries", e.Title, installed.Equal(e), installed.Key(), e.Key())
+		}
+	}
+
+	if installed.Equal(nil) || !(*Entry)(nil).Equal(nil) {
+		t.Errorf("Equal() with a nil entry = %t, want only nil entries equal", installed.Equal(nil))
+	}
+
+	got := Merge(&History{Entries: []*Entry{installed, nil, failed}}, nil, &History{Entries: []*Entry{again, uninstalled}})
+	if diff := cmp.Diff([]*Entry{installed, failed, uninstalled}, got.Entries); diff != "" {
+		t.Errorf("Merge() returned diff (-want +got):\n%s", diff)
+	}
+}
@@ -16,8 +16,14 @@
 package updates
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/cabbie/cablib"
@@ -32,6 +38,59 @@ type Identity struct {
 	UpdateID       string
 }
 
+var guidRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// String returns the identity in the <UpdateID>.<RevisionNumber> form used by WSUS.
+func (i Identity) String() string {
+	return fmt.Sprintf("%s.%d", i.UpdateID, i.RevisionNumber)
+}
+
+// MarshalText encodes the identity as <UpdateID>.<RevisionNumber>, which lets it key JSON maps.
+func (i Identity) MarshalText() ([]byte, error) {
+	return []byte(i.String()), nil
+}
+
+// UnmarshalText decodes an identity written by MarshalText, requiring a valid GUID and a
+// non-negative revision number.
+func (i *Identity) UnmarshalText(text []byte) error {
+	s := string(text)
+	dot := strings.LastIndex(s, ".")
+	if dot < 0 {
+		return fmt.Errorf("identity %q is not in the form <UpdateID>.<RevisionNumber>", s)
+	}
+	id, rev := s[:dot], s[dot+1:]
+	if !guidRegex.MatchString(id) {
+		return fmt.Errorf("identity %q has an invalid UpdateID %q", s, id)
+	}
+	n, err := strconv.Atoi(rev)
+	if err != nil || n < 0 {
+		return fmt.Errorf("identity %q has an invalid RevisionNumber %q", s, rev)
+	}
+	*i = Identity{UpdateID: id, RevisionNumber: n}
+	return nil
+}
+
+// identityFields has the fields of Identity without its methods, so it encodes as an object.
+type identityFields Identity
+
+// MarshalJSON keeps encoding identities as objects, as they were before Identity implemented
+// MarshalText, so existing exports and approval decisions stay readable.
+func (i Identity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(identityFields(i))
+}
+
+// UnmarshalJSON decodes an identity from either an object or a <UpdateID>.<RevisionNumber> string.
+func (i *Identity) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return i.UnmarshalText([]byte(s))
+	}
+	return json.Unmarshal(data, (*identityFields)(i))
+}
+
 // Category is information about a single Category.
 type Category struct {
 	Name       string
@@ -39,9 +98,61 @@ type Category struct {
 	CategoryID string
 }
 
+// CategoryIDs of well known update classifications.
+const (
+	CategoryCriticalUpdates   = "E6CF1350-C01B-414D-A61F-263D14D133B4"
+	CategoryDefinitionUpdates = "E0789628-CE08-4437-BE74-2495B842F43B"
+	CategoryDrivers           = "EBFC1FC5-71A4-4F7B-9ACA-3B9A503104A0"
+	CategorySecurityUpdates   = "0FA1201D-4330-4FA8-8AE9-B877473B6441"
+)
+
+// InstallationBehavior describes how an update behaves while it is installed.
+type InstallationBehavior struct {
+	CanRequestUserInput         bool
+	Impact                      int
+	RebootBehavior              int
+	RequiresNetworkConnectivity bool
+}
+
+// Reboot behaviors reported by InstallationBehavior.
+// https://docs.microsoft.com/en-us/windows/win32/api/wuapi/ne-wuapi-installationrebootbehavior
+const (
+	NeverReboots = iota
+	AlwaysRequiresReboot
+	CanRequestReboot
+)
+
+// Installation impacts reported by InstallationBehavior.
+// https://docs.microsoft.com/en-us/windows/win32/api/wuapi/ne-wuapi-installationimpact
+const (
+	NormalImpact = iota
+	MinorImpact
+	RequiresExclusiveHandling
+)
+
+// ImpactName returns the name of an InstallationBehavior Impact value.
+func ImpactName(i int) string {
+	switch i {
+	case NormalImpact:
+		return "Normal"
+	case MinorImpact:
+		return "Minor"
+	case RequiresExclusiveHandling:
+		return "RequiresExclusiveHandling"
+	}
+	return fmt.Sprintf("Unknown(%d)", i)
+}
+
+// BundledUpdate is an update carried inside another, such as the servicing stack update included
+// in a combined cumulative update.
+type BundledUpdate struct {
+	Identity Identity
+	Title    string
+}
+
 // Update contains the  update interface and properties that are available to an update.
 type Update struct {
-	Item                     *ole.IDispatch
+	Item                     *ole.IDispatch `json:"-"`
 	Title                    string
 	CanRequireSource         bool
 	Categories               []Category
@@ -74,6 +185,111 @@ type Update struct {
 	PerUser                  bool
 	AutoSelection            int
 	AutoDownload             int
+	DeploymentAction         int
+	InstallationBehavior     InstallationBehavior
+	BundledUpdates           []BundledUpdate
+}
+
+// DeploymentActionName returns the name of an IUpdate DeploymentAction value, which for updates
+// offered by WSUS reflects the approval of the update.
+// https://docs.microsoft.com/en-us/windows/win32/api/wuapi/ne-wuapi-deploymentaction
+func DeploymentActionName(a int) string {
+	switch a {
+	case 0:
+		return "None"
+	case 1:
+		return "Installation"
+	case 2:
+		return "Uninstallation"
+	case 3:
+		return "Detection"
+	case 4:
+		return "OptionalInstallation"
+	}
+	return fmt.Sprintf("Unknown(%d)", a)
+}
+
+// Operation is the UpdateOperation recorded for an update history entry.
+// https://docs.microsoft.com/en-us/windows/win32/api/wuapi/ne-wuapi-updateoperation
+type Operation int
+
+// UpdateOperation values.
+const (
+	OperationInstallation   Operation = 1
+	OperationUninstallation Operation = 2
+)
+
+func (o Operation) String() string {
+	switch o {
+	case OperationInstallation:
+		return "Installation"
+	case OperationUninstallation:
+		return "Uninstallation"
+	}
+	return fmt.Sprintf("Unknown(%d)", int(o))
+}
+
+// ServerSelection is the type of update server an operation used.
+// https://docs.microsoft.com/en-us/windows/win32/api/wuapi/ne-wuapi-serverselection
+type ServerSelection int
+
+// ServerSelection values.
+const (
+	ServerSelectionDefault       ServerSelection = 0
+	ServerSelectionManagedServer ServerSelection = 1
+	ServerSelectionWindowsUpdate ServerSelection = 2
+	ServerSelectionOthers        ServerSelection = 3
+)
+
+func (s ServerSelection) String() string {
+	switch s {
+	case ServerSelectionDefault:
+		return "Default"
+	case ServerSelectionManagedServer:
+		return "ManagedServer"
+	case ServerSelectionWindowsUpdate:
+		return "WindowsUpdate"
+	case ServerSelectionOthers:
+		return "Others"
+	}
+	return fmt.Sprintf("Unknown(%d)", int(s))
+}
+
+// OperationResultCode is the result of an operation on an update.
+// https://docs.microsoft.com/en-us/windows/win32/api/wuapi/ne-wuapi-operationresultcode
+type OperationResultCode int
+
+// OperationResultCode values.
+const (
+	ResultNotStarted          OperationResultCode = 0
+	ResultInProgress          OperationResultCode = 1
+	ResultSucceeded           OperationResultCode = 2
+	ResultSucceededWithErrors OperationResultCode = 3
+	ResultFailed              OperationResultCode = 4
+	ResultAborted             OperationResultCode = 5
+)
+
+// Succeeded reports whether the operation completed, including with errors.
+func (rc OperationResultCode) Succeeded() bool {
+	return rc == ResultSucceeded || rc == ResultSucceededWithErrors
+}
+
+func (rc OperationResultCode) String() string {
+	switch rc {
+	case ResultNotStarted:
+		return "NotStarted"
+	case ResultInProgress:
+		return "InProgress"
+	case ResultSucceeded:
+		return "Succeeded"
+	case ResultSucceededWithErrors:
+		return "SucceededWithErrors"
+	case ResultFailed:
+		return "Failed"
+	case ResultAborted:
+		return "Aborted"
+	}
+	return fmt.Sprintf("Unknown(%d)", int(rc))
 }
 
 // New expands an IUpdate object into a usable go struct.
@@ -123,6 +339,16 @@ func New(item *ole.IDispatch) (*Update, []error) {
 			if err != nil {
 				errors = append(errors, err)
 			}
+		case "updates.InstallationBehavior":
+			data[p], err = u.toInstallationBehavior(p)
+			if err != nil {
+				errors = append(errors, err)
+			}
+		case "[]updates.BundledUpdate":
+			data[p], err = u.toBundledUpdates(p)
+			if err != nil {
+				errors = append(errors, err)
+			}
 		}
 	}
 
@@ -133,8 +359,53 @@ func New(item *ole.IDispatch) (*Update, []error) {
 	return u, errors
 }
 
+// searchResult is the serialized form of a search result and the metadata of the run that saved it.
+type searchResult struct {
+	Meta    map[string]string `json:"meta,omitempty"`
+	Updates []*Update         `json:"updates"`
+}
+
+// WriteJSON serializes a search result and the metadata of the run that produced it to w as JSON for
+// later offline analysis. The COM item of each update is omitted, as is meta when empty.
+func WriteJSON(w io.Writer, ups []*Update, meta map[string]string) error {
+	if ups == nil {
+		ups = []*Update{}
+	}
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	if err := e.Encode(searchResult{Meta: meta, Updates: ups}); err != nil {
+		return fmt.Errorf("error encoding updates: %v", err)
+	}
+	return nil
+}
+
+// ReadJSON loads a search result previously written by WriteJSON, or the bare array of updates
+// written before metadata was recorded. The Item of each returned update is nil, so updates can be
+// filtered and reported on but not acted on.
+func ReadJSON(r io.Reader) ([]*Update, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("error decoding updates: %v", err)
+	}
+	if t := bytes.TrimSpace(raw); len(t) > 0 && t[0] == '[' {
+		var ups []*Update
+		if err := json.Unmarshal(raw, &ups); err != nil {
+			return nil, fmt.Errorf("error decoding updates: %v", err)
+		}
+		return ups, nil
+	}
+	var res searchResult
+	if err := json.Unmarshal(raw, &res); err != nil {
+		return nil, fmt.Errorf("error decoding updates: %v", err)
+	}
+	return res.Updates, nil
+}
+
 // AcceptEula accepts the Microsoft Software License Terms that are associated with Windows Update.
 func (up *Update) AcceptEula() error {
+	if err := cablib.CheckWritable("accept EULA"); err != nil {
+		return err
+	}
 	r, err := oleutil.CallMethod(up.Item, "AcceptEula")
 	if err != nil {
 		return fmt.Errorf("unable to accept Eula: [%s] [%v]", errors.UpdateError(r.Val), err)
@@ -145,6 +416,9 @@ func (up *Update) AcceptEula() error {
 
 // Hide sets a Boolean value that hides the update from future search results.
 func (up *Update) Hide() error {
+	if err := cablib.CheckWritable("hide update"); err != nil {
+		return err
+	}
 	r, err := oleutil.PutProperty(up.Item, "IsHidden", true)
 	if err != nil {
 		return fmt.Errorf("unable to hide update: [%s] [%v]", errors.UpdateError(r.Val), err)
@@ -155,6 +429,9 @@ func (up *Update) Hide() error {
 
 // UnHide sets a Boolean value that makes the update available in future search results.
 func (up *Update) UnHide() error {
+	if err := cablib.CheckWritable("unhide update"); err != nil {
+		return err
+	}
 	r, err := oleutil.PutProperty(up.Item, "IsHidden", false)
 	if err != nil {
 		return fmt.Errorf("failed to unhide update: [%s] [%v]", errors.UpdateError(r.Val), err)
@@ -298,12 +575,115 @@ func (up *Update) toIdentity(property string) (Identity, error) {
 		UpdateID: uid.ToString()}, nil
 }
 
+// toBundledUpdates reads the identity and title of each update in the collection property.
+func (up *Update) toBundledUpdates(property string) ([]BundledUpdate, error) {
+	p, err := oleutil.GetProperty(up.Item, property)
+	if err != nil {
+		return nil, err
+	}
+	pd := p.ToIDispatch()
+	defer pd.Release()
+
+	count, err := cablib.Count(pd)
+	if err != nil {
+		return nil, err
+	}
+
+	var bs []BundledUpdate
+	for i := 0; i < count; i++ {
+		item, err := oleutil.GetProperty(pd, "Item", i)
+		if err != nil {
+			return bs, err
+		}
+		b := &Update{Item: item.ToIDispatch()}
+		id, err := b.toIdentity("Identity")
+		if err != nil {
+			b.Item.Release()
+			return bs, err
+		}
+		title, err := b.toString("Title")
+		b.Item.Release()
+		if err != nil {
+			return bs, err
+		}
+		bs = append(bs, BundledUpdate{Identity: id, Title: title})
+	}
+	return bs, nil
+}
+
+// toInstallationBehavior reads the InstallationBehavior of the update. An update without one is
+// assumed to be able to request a reboot, so it is not reported as installing without a reboot.
+func (up *Update) toInstallationBehavior(property string) (InstallationBehavior, error) {
+	p, err := oleutil.GetProperty(up.Item, property)
+	if err != nil {
+		return InstallationBehavior{}, err
+	}
+	pd := p.ToIDispatch()
+	if pd == nil {
+		return InstallationBehavior{RebootBehavior: CanRequestReboot}, nil
+	}
+	defer pd.Release()
+
+	var b InstallationBehavior
+	if b.CanRequestUserInput, err = behaviorBool(pd, "CanRequestUserInput"); err != nil {
+		return b, err
+	}
+	if b.Impact, err = behaviorInt(pd, "Impact"); err != nil {
+		return b, err
+	}
+	if b.RebootBehavior, err = behaviorInt(pd, "RebootBehavior"); err != nil {
+		return b, err
+	}
+	if b.RequiresNetworkConnectivity, err = behaviorBool(pd, "RequiresNetworkConnectivity"); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+// behaviorBool reads the boolean property of an IUpdateInstallationBehavior.
+func behaviorBool(pd *ole.IDispatch, property string) (bool, error) {
+	v, err := oleutil.GetProperty(pd, property)
+	if err != nil {
+		return false, err
+	}
+	defer v.Clear()
+	b, ok := v.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("InstallationBehavior.%s is %T, not a bool", property, v.Value())
+	}
+	return b, nil
+}
+
+// behaviorInt reads the enumeration property of an IUpdateInstallationBehavior.
+func behaviorInt(pd *ole.IDispatch, property string) (int, error) {
+	v, err := oleutil.GetProperty(pd, property)
+	if err != nil {
+		return 0, err
+	}
+	defer v.Clear()
+	i, ok := v.Value().(int32)
+	if !ok {
+		return 0, fmt.Errorf("InstallationBehavior.%s is %T, not an int32", property, v.Value())
+	}
+	return int(i), nil
+}
+
+// RequiresReboot reports whether installing the update may leave the device needing a reboot,
+// either because the update always or can request a reboot, or because it is already waiting on one.
+func (up *Update) RequiresReboot() bool {
+	return up.RebootRequired || up.InstallationBehavior.RebootBehavior != NeverReboots
+}
+
 func (up *Update) String() string {
-	return fmt.Sprintf("Title: %s\n"+
+	s := fmt.Sprintf("Title: %s\n"+
 		"Categories: %+v\n"+
 		"MsrcSeverity: %s\n"+
 		"EulaAccepted: %t\n"+
 		"KBArticleIDs: %v", up.Title, up.Categories, up.MsrcSeverity, up.EulaAccepted, up.KBArticleIDs)
+	if up.SupportURL != "" {
+		s += fmt.Sprintf("\nSupportURL: %s", up.SupportURL)
+	}
+	return s
 }
 
 func (up *Update) fillStruct(m map[string]interface{}) error {
@@ -328,3 +708,49 @@ func (up *Update) InCategories(categories []string) bool {
 	}
 	return false
 }
+
+// IsServicingStack reports whether this is a servicing stack update, which must be installed before
+// the cumulative and security updates released alongside it. Updates are recognized by their title,
+// as the Windows Update Agent does not classify them.
+func (up *Update) IsServicingStack() bool {
+	return isServicingStackTitle(up.Title)
+}
+
+// BundlesServicingStack reports whether the update carries its own servicing stack update, as
+// combined cumulative updates do, so that no separate one needs to be installed first.
+func (up *Update) BundlesServicingStack() bool {
+	for _, b := range up.BundledUpdates {
+		if isServicingStackTitle(b.Title) {
+			return true
+		}
+	}
+	return false
+}
+
+// Products returns the names of the product categories of the update, such as "Windows 10".
+func (up *Update) Products() []string {
+	var ps []string
+	for _, c := range up.Categories {
+		if c.Type == "Product" {
+			ps = append(ps, c.Name)
+		}
+	}
+	return ps
+}
+
+func isServicingStackTitle(title string) bool {
+	return strings.Contains(strings.ToLower(title), "servicing stack update")
+}
+
+// InBulletins determines whether or not this update is associated with one of the supplied
+// security bulletin IDs, such as MS17-010. Bulletin IDs are matched without regard to case.
+func (up *Update) InBulletins(bulletins []string) bool {
+	for _, b := range up.SecurityBulletinIDs {
+		for _, want := range bulletins {
+			if strings.EqualFold(strings.TrimSpace(want), b) {
+				return true
+			}
+		}
+	}
+	return false
+}
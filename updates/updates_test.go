@@ -15,8 +15,12 @@
 package updates
 
 import (
+	"bytes"
+	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -46,6 +50,56 @@ func TestInCategories(t *testing.T) {
 	}
 }
 
+func TestInBulletins(t *testing.T) {
+	b := Update{SecurityBulletinIDs: []string{"MS17-010"}}
+	for _, tt := range []struct {
+		in  []string
+		out bool
+	}{
+		{[]string{"MS17-010"}, true},
+		{[]string{"MS16-047", "ms17-010"}, true},
+		{[]string{"MS16-047"}, false},
+		{nil, false},
+	} {
+		o := b.InBulletins(tt.in)
+		if o != tt.out {
+			t.Errorf("InBulletins(%v) = %v, want %v", tt.in, o, tt.out)
+		}
+	}
+}
+
+func TestIsServicingStack(t *testing.T) {
+	for _, tt := range []struct {
+		title string
+		out   bool
+	}{
+		{"2020-06 Servicing Stack Update for Windows 10 Version 1909 for x64-based Systems (KB4560959)", true},
+		{"2020-06 Cumulative Update for Windows 10 Version 1909 for x64-based Systems (KB4560960)", false},
+	} {
+		u := Update{Title: tt.title}
+		if o := u.IsServicingStack(); o != tt.out {
+			t.Errorf("IsServicingStack(%q) = %v, want %v", tt.title, o, tt.out)
+		}
+	}
+}
+
+func TestBundlesServicingStack(t *testing.T) {
+	combined := Update{BundledUpdates: []BundledUpdate{{Title: "2021-10 Servicing Stack Update for Windows 10 Version 21H1 (KB5005698)"}}}
+	if !combined.BundlesServicingStack() {
+		t.Errorf("BundlesServicingStack() of combined update = false, want true")
+	}
+	if (&Update{}).BundlesServicingStack() {
+		t.Errorf("BundlesServicingStack() of update without bundles = true, want false")
+	}
+}
+
+func TestProducts(t *testing.T) {
+	u := Update{Categories: []Category{{Name: "Security Updates", Type: "UpdateClassification"}, {Name: "Windows 10", Type: "Product"}}}
+	if got, want := u.Products(), []string{"Windows 10"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Products() = %v, want %v", got, want)
+	}
+}
+
 func TestFillStruct(t *testing.T) {
 	data := make(map[string]interface{})
 	for _, tt := range []struct {
@@ -65,3 +119,160 @@ func TestFillStruct(t *testing.T) {
 		}
 	}
 }
+
+func TestJSONRoundTrip(t *testing.T) {
+	want := []*Update{
+		{
+			Title:                    "Title string",
+			Categories:               []Category{{Name: "foo", CategoryID: "1234"}},
+			Identity:                 Identity{RevisionNumber: 200, UpdateID: "abcd"},
+			KBArticleIDs:             []string{"1234567"},
+			LastDeploymentChangeTime: time.Date(2020, 10, 15, 0, 0, 0, 0, time.UTC),
+			IsBeta:                   true,
+			SupportURL:               "https://support.microsoft.com/help/1234567",
+		},
+	}
+	var b bytes.Buffer
+	if err := WriteJSON(&b, want, map[string]string{"ticket": "CHG1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), `"ticket": "CHG1"`) {
+		t.Errorf("WriteJSON() = %s, want the metadata recorded", b.String())
+	}
+	got, err := ReadJSON(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadJSON(WriteJSON(%+v)) = %+v", want[0], got[0])
+	}
+
+	// Search results saved before metadata was recorded are a bare array.
+	legacy, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = ReadJSON(bytes.NewReader(legacy))
+	if err != nil {
+		t.Fatalf("ReadJSON(%s) returned unexpected error: %v", legacy, err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadJSON(%s) = %+v", legacy, got[0])
+	}
+}
+
+func TestString(t *testing.T) {
+	for _, tt := range []struct {
+		in   Update
+		want bool
+	}{
+		{Update{Title: "no url"}, false},
+		{Update{Title: "url", SupportURL: "https://support.microsoft.com/help/1234567"}, true},
+	} {
+		if got := strings.Contains(tt.in.String(), "SupportURL: "); got != tt.want {
+			t.Errorf("String() of %q contains SupportURL = %t, want %t", tt.in.Title, got, tt.want)
+		}
+	}
+}
+
+func TestOperationString(t *testing.T) {
+	for _, tt := range []struct {
+		in   Operation
+		want string
+	}{
+		{OperationInstallation, "Installation"},
+		{OperationUninstallation, "Uninstallation"},
+		{Operation(3), "Unknown(3)"},
+	} {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Operation(%d).String() = %q, want %q", int(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestOperationResultCode(t *testing.T) {
+	for _, tt := range []struct {
+		in        OperationResultCode
+		want      string
+		succeeded bool
+	}{
+		{ResultNotStarted, "NotStarted", false},
+		{ResultInProgress, "InProgress", false},
+		{ResultSucceeded, "Succeeded", true},
+		{ResultSucceededWithErrors, "SucceededWithErrors", true},
+		{ResultFailed, "Failed", false},
+		{ResultAborted, "Aborted", false},
+		{OperationResultCode(9), "Unknown(9)", false},
+	} {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("OperationResultCode(%d).String() = %q, want %q", int(tt.in), got, tt.want)
+		}
+		if got := tt.in.Succeeded(); got != tt.succeeded {
+			t.Errorf("OperationResultCode(%d).Succeeded() = %t, want %t", int(tt.in), got, tt.succeeded)
+		}
+	}
+}
+
+func TestServerSelectionString(t *testing.T) {
+	for _, tt := range []struct {
+		in   ServerSelection
+		want string
+	}{
+		{ServerSelectionDefault, "Default"},
+		{ServerSelectionManagedServer, "ManagedServer"},
+		{ServerSelectionWindowsUpdate, "WindowsUpdate"},
+		{ServerSelectionOthers, "Others"},
+		{ServerSelection(7), "Unknown(7)"},
+	} {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("ServerSelection(%d).String() = %q, want %q", int(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestIdentityText(t *testing.T) {
+	want := Identity{UpdateID: "0A4B5E1C-7B3D-4E2F-9C1A-5D6E7F8A9B0C", RevisionNumber: 201}
+	text, err := want.MarshalText()
+	if err != nil {
+		t.Fatalf("MarshalText() returned unexpected error: %v", err)
+	}
+	if string(text) != "0A4B5E1C-7B3D-4E2F-9C1A-5D6E7F8A9B0C.201" {
+		t.Errorf("MarshalText() = %q, want <UpdateID>.<RevisionNumber>", text)
+	}
+	var got Identity
+	if err := got.UnmarshalText(text); err != nil || got != want {
+		t.Errorf("UnmarshalText(%q) = %+v, %v, want %+v", text, got, err, want)
+	}
+
+	for _, bad := range []string{"", "0A4B5E1C-7B3D-4E2F-9C1A-5D6E7F8A9B0C", "not-a-guid.1", "0A4B5E1C-7B3D-4E2F-9C1A-5D6E7F8A9B0C.x", "0A4B5E1C-7B3D-4E2F-9C1A-5D6E7F8A9B0C.-1"} {
+		var i Identity
+		if err := i.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalText(%q) = %+v, want error", bad, i)
+		}
+	}
+}
+
+func TestIdentityJSON(t *testing.T) {
+	id := Identity{UpdateID: "0A4B5E1C-7B3D-4E2F-9C1A-5D6E7F8A9B0C", RevisionNumber: 201}
+	b, err := json.Marshal(map[Identity][]Identity{id: {id}})
+	if err != nil {
+		t.Fatalf("json.Marshal() returned unexpected error: %v", err)
+	}
+	want := `{"0A4B5E1C-7B3D-4E2F-9C1A-5D6E7F8A9B0C.201":[{"RevisionNumber":201,"UpdateID":"0A4B5E1C-7B3D-4E2F-9C1A-5D6E7F8A9B0C"}]}`
+	if string(b) != want {
+		t.Errorf("json.Marshal() = %s, want %s", b, want)
+	}
+
+	var got map[Identity][]Identity
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("json.Unmarshal(%s) returned unexpected error: %v", b, err)
+	}
+	if len(got) != 1 || len(got[id]) != 1 || got[id][0] != id {
+		t.Errorf("json.Unmarshal(%s) = %+v, want %+v", b, got, id)
+	}
+
+	var fromString Identity
+	if err := json.Unmarshal([]byte(`"0A4B5E1C-7B3D-4E2F-9C1A-5D6E7F8A9B0C.201"`), &fromString); err != nil || fromString != id {
+		t.Errorf("json.Unmarshal(string) = %+v, %v, want %+v", fromString, err, id)
+	}
+}
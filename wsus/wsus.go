@@ -18,9 +18,11 @@
 package wsus
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/google/cabbie/cablib"
@@ -46,6 +48,12 @@ const (
 	Others
 )
 
+const (
+	// clientWebService is the path to the client web service endpoint hosted by every WSUS server.
+	clientWebService = "/ClientWebService/client.asmx"
+	precheckTimeout  = 30 * time.Second
+)
+
 // WSUS contains local managed server information.
 type WSUS struct {
 	CurrentServer   string
@@ -95,6 +103,11 @@ func Init(servers []string) (*WSUS, error) {
 		return &w, nil
 	}
 
+	// Leave the client configuration untouched in read-only mode.
+	if cablib.ReadOnly() {
+		return current()
+	}
+
 	wlog, err = eventlog.Open("Cabbie WSUS")
 	if err != nil {
 		return &w, err
@@ -115,6 +128,21 @@ func Init(servers []string) (*WSUS, error) {
 	return &w, nil
 }
 
+// current describes the WSUS configuration already present on the local update client.
+func current() (*WSUS, error) {
+	w := WSUS{ServerSelection: WindowsUpdate}
+	u, err := ConfiguredServer()
+	if err != nil {
+		return &w, err
+	}
+	if u != "" {
+		w.CurrentServer = strings.TrimPrefix(u, "https://")
+		w.Servers = []string{w.CurrentServer}
+		w.ServerSelection = ManagedServer
+	}
+	return &w, nil
+}
+
 // order returns a list of WSUS servers from fastest to slowest.
 func (w *WSUS) order(servers []string) {
 
@@ -136,6 +164,9 @@ func (w *WSUS) order(servers []string) {
 
 // Set configures the update client to use the requested WSUS server.
 func (w *WSUS) Set(index int) error {
+	if err := cablib.CheckWritable("configure WSUS server"); err != nil {
+		return err
+	}
 	k, err := registry.OpenKey(registry.LOCAL_MACHINE, cablib.WUReg, registry.ALL_ACCESS)
 	if err != nil && err != registry.ErrNotExist {
 		return err
@@ -173,6 +204,9 @@ func (w *WSUS) Set(index int) error {
 
 // Clear sets WSUS client configurations back to Windows defaults.
 func (w *WSUS) Clear() error {
+	if err := cablib.CheckWritable("clear WSUS configuration"); err != nil {
+		return err
+	}
 	w.CurrentServer = ""
 	w.ServerSelection = WindowsUpdate
 
@@ -189,3 +223,52 @@ func (w *WSUS) Clear() error {
 	k.DeleteValue("WUStatusServer")
 	return registry.DeleteKey(k, "AU")
 }
+
+// ConfiguredServer returns the WSUS server URL the local update client is configured to use.
+func ConfiguredServer() (string, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, cablib.WUReg, registry.QUERY_VALUE)
+	if err != nil {
+		if err == registry.ErrNotExist {
+			return "", nil
+		}
+		return "", err
+	}
+	defer k.Close()
+
+	u, _, err := k.GetStringValue("WUServer")
+	if err != nil && err != registry.ErrNotExist {
+		return "", err
+	}
+	return u, nil
+}
+
+// CheckConnectivity verifies that the client web service of the configured WSUS server can be
+// reached, distinguishing an unavailable WSUS server from an empty or failing update search.
+func CheckConnectivity(ctx context.Context) error {
+	u, err := ConfiguredServer()
+	if err != nil {
+		return fmt.Errorf("unable to read configured WSUS server: %v", err)
+	}
+	if u == "" {
+		return fmt.Errorf("no WSUS server is configured in %s", cablib.WUReg)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, precheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u+clientWebService, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request for WSUS server %q: %v", u, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("WSUS server %q is unreachable: %v", u, err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("WSUS server %q is unavailable: %s", u, resp.Status)
+	}
+	return nil
+}